@@ -5,12 +5,16 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"time"
 
+	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/swarm"
 	"github.com/docker/docker/client"
 	"github.com/hashicorp/nomad/api"
 	"github.com/pkg/errors"
 	"github.com/portainer/portainer-updater/dockerstandalone"
+	"github.com/portainer/portainer-updater/dockerswarm"
 	"github.com/portainer/portainer-updater/nomad"
 	"github.com/rs/zerolog/log"
 )
@@ -22,13 +26,34 @@ type EnvType string
 
 const (
 	EnvTypeDockerStandalone EnvType = "standalone"
+	EnvTypeDockerSwarm      EnvType = "swarm"
 	EnvTypeNomad            EnvType = "nomad"
 )
 
 type AgentCommand struct {
-	EnvType    EnvType `kong:"help='The environment type',default='standalone',enum='standalone,nomad'"`
-	ScheduleId string  `arg:"" help:"Schedule ID of the agent to upgrade to. e.g. 1" name:"schedule-id"`
-	Image      string  `arg:"" help:"Image of the agent to upgrade to. e.g. portainer/agent:latest" name:"image" default:"portainer/agent:latest"`
+	EnvType              EnvType                 `kong:"help='The environment type',default='standalone',enum='standalone,swarm,nomad'"`
+	UpdateOrder          string                  `kong:"help='Order of operations when Docker Swarm replaces the agent tasks (stop-first or start-first), the service setting is kept when empty'"`
+	UpdateMonitor        time.Duration           `kong:"help='Duration Docker Swarm monitors the new tasks for failure, the service setting is kept when 0',default='0'"`
+	Timeout              time.Duration           `kong:"help='Maximum time to wait for the update to complete',default='5m'"`
+	StackPolicy          dockerswarm.StackPolicy `kong:"help='How to handle a Docker Swarm service deployed with docker stack deploy',default='warn',enum='warn,refuse'"`
+	StackSnippet         bool                    `kong:"help='Print the stack file changes matching the update when the service is part of a stack'"`
+	ComposeRewrite       bool                    `kong:"help='Update the image in the compose file when the agent container is managed by docker compose'"`
+	ComposeFile          string                  `kong:"help='Path of the compose file to update, mounted in the updater container. Defaults to the files listed in the container compose labels'"`
+	Container            string                  `kong:"help='ID or name of the agent container to update, discovered when empty'"`
+	All                  bool                    `kong:"help='Update every agent container found on the host instead of a single one'"`
+	Filter               []string                `kong:"help='Only update the agent containers matching the filter, label=<key>[=<value>] or name=<name>, implies --all'"`
+	Podman               bool                    `kong:"help='Update the agent container through the Docker compatible API of Podman, detected automatically when not set'"`
+	NomadNamespace       string                  `kong:"help='Nomad namespace of the agent jobs, all namespaces are searched when empty'"`
+	NomadJob             string                  `kong:"help='ID of the Nomad job running the agent, discovered when empty'"`
+	AgentImagePrefix     []string                `kong:"help='Additional image prefix identifying the agent containers, services and tasks, e.g. registry.example.com/agent'"`
+	NomadMaxParallel     int                     `kong:"help='Override the job max_parallel for the upgrade, the job setting is kept when 0'"`
+	NomadHealthCheck     string                  `kong:"help='Override the job health_check for the upgrade (checks, task_states or manual), the job setting is kept when empty'"`
+	NomadMinHealthyTime  time.Duration           `kong:"help='Override the job min_healthy_time for the upgrade, the job setting is kept when 0'"`
+	NomadHealthyDeadline time.Duration           `kong:"help='Override the job healthy_deadline for the upgrade, the job setting is kept when 0'"`
+	NomadCanary          bool                    `kong:"help='Upgrade a single canary allocation per task group first and promote the deployment once it is healthy and its agent runs with the new update ID'"`
+	NomadAutoRevert      string                  `kong:"help='Override the job auto_revert for the upgrade (true or false), the job setting is kept when empty'"`
+	ScheduleId           string                  `arg:"" help:"Schedule ID of the agent to upgrade to. e.g. 1" name:"schedule-id"`
+	Image                string                  `arg:"" help:"Image of the agent to upgrade to. e.g. portainer/agent:latest" name:"image" default:"portainer/agent:latest"`
 }
 
 func (r *AgentCommand) Run() error {
@@ -37,6 +62,8 @@ func (r *AgentCommand) Run() error {
 	switch r.EnvType {
 	case "standalone":
 		return r.runStandalone(ctx)
+	case "swarm":
+		return r.runSwarm(ctx)
 	case "nomad":
 		return r.runNomad(ctx)
 	}
@@ -45,6 +72,10 @@ func (r *AgentCommand) Run() error {
 }
 
 func (r *AgentCommand) runStandalone(ctx context.Context) error {
+	if r.Container != "" && (r.All || len(r.Filter) > 0) {
+		return errors.New("--container updates a single container and can't be combined with --all or --filter")
+	}
+
 	dockerCli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
 	if err != nil {
 		log.Fatal().Err(err).Msg("Unable to initialize Docker client")
@@ -54,84 +85,267 @@ func (r *AgentCommand) runStandalone(ctx context.Context) error {
 		Str("image", r.Image).
 		Str("schedule-id", r.ScheduleId).
 		Msg("Updating Portainer agent")
-	oldContainer, err := dockerstandalone.FindAgentContainer(ctx, dockerCli)
+
+	if r.All || len(r.Filter) > 0 {
+		return r.updateAgentContainers(ctx, dockerCli)
+	}
+
+	oldContainer, err := r.findAgentContainer(ctx, dockerCli)
 	if err != nil {
 		return errors.WithMessage(err, "failed finding container id")
 	}
 
+	_, err = r.updateAgentContainer(ctx, dockerCli, oldContainer)
+
+	return err
+}
+
+// updateAgentContainers updates the matching agent containers one after the other, a failure doesn't stop the next updates
+func (r *AgentCommand) updateAgentContainers(ctx context.Context, dockerCli *client.Client) error {
+	candidates, err := dockerstandalone.FindAgentContainers(ctx, dockerCli, r.AgentImagePrefix...)
+	if err != nil {
+		return errors.WithMessage(err, "failed finding containers")
+	}
+
+	candidates, err = dockerstandalone.FilterCandidates(candidates, r.Filter)
+	if err != nil {
+		return err
+	}
+
+	if len(candidates) == 0 {
+		return errors.New("no agent container matches the filters")
+	}
+
+	updated, skipped, failed := 0, 0, 0
+	for _, candidate := range candidates {
+		done, err := r.updateAgentContainer(ctx, dockerCli, &candidate.Container)
+
+		switch {
+		case err != nil:
+			log.Err(err).
+				Str("containerId", candidate.Container.ID).
+				Strs("names", candidate.Container.Names).
+				Msg("Agent container update failed")
+
+			failed++
+		case done:
+			log.Info().
+				Str("containerId", candidate.Container.ID).
+				Strs("names", candidate.Container.Names).
+				Msg("Agent container updated")
+
+			updated++
+		default:
+			skipped++
+		}
+	}
+
+	log.Info().
+		Int("containers", len(candidates)).
+		Int("updated", updated).
+		Int("skipped", skipped).
+		Int("failed", failed).
+		Msg("Agent containers update summary")
+
+	if failed > 0 {
+		return errors.Errorf("failed to update %d of %d agent containers", failed, len(candidates))
+	}
+
+	return nil
+}
+
+// updateAgentContainer recreates the agent container with the new image, it returns false when the container is already updated
+// or already runs the image
+func (r *AgentCommand) updateAgentContainer(ctx context.Context, dockerCli *client.Client, oldContainer *types.Container) (bool, error) {
 	if oldContainer.Labels != nil && oldContainer.Labels[UpdateScheduleIDLabel] == r.ScheduleId {
+		log.Info().
+			Str("containerId", oldContainer.ID).
+			Msg("Agent already updated")
+
+		return false, nil
+	}
+
+	options := dockerstandalone.UpdateOptions{
+		RewriteComposeFile: r.ComposeRewrite,
+		ComposeFile:        r.ComposeFile,
+		Podman:             r.Podman,
+	}
+
+	return dockerstandalone.Update(ctx, dockerCli, oldContainer.ID, r.Image, options, func(config *container.Config) {
+		config.Env = r.setUpdateIDEnv(config.Env)
+		config.Labels = r.setScheduleIDLabel(config.Labels)
+	})
+}
+
+func (r *AgentCommand) findAgentContainer(ctx context.Context, dockerCli *client.Client) (*types.Container, error) {
+	if r.Container != "" {
+		return dockerstandalone.FindAgentContainerByName(ctx, dockerCli, r.Container, r.AgentImagePrefix...)
+	}
+
+	return dockerstandalone.FindAgentContainer(ctx, dockerCli, r.AgentImagePrefix...)
+}
+
+func (r *AgentCommand) runSwarm(ctx context.Context) error {
+	dockerCli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		log.Fatal().Err(err).Msg("Unable to initialize Docker client")
+	}
+
+	log.Info().
+		Str("image", r.Image).
+		Str("schedule-id", r.ScheduleId).
+		Msg("Updating Portainer agent on swarm environment")
+
+	service, err := dockerswarm.FindAgentService(ctx, dockerCli, r.AgentImagePrefix...)
+	if err != nil {
+		return errors.WithMessage(err, "failed finding service")
+	}
+
+	containerSpec := service.Spec.TaskTemplate.ContainerSpec
+	if containerSpec.Labels != nil && containerSpec.Labels[UpdateScheduleIDLabel] == r.ScheduleId {
 		log.Info().Msg("Agent already updated")
 
 		return nil
 	}
 
-	return dockerstandalone.Update(ctx, dockerCli, oldContainer.ID, r.Image, func(config *container.Config) {
-		foundIndex := -1
-		for index, env := range config.Env {
-			if strings.HasPrefix(env, "UPDATE_ID=") {
-				foundIndex = index
-			}
-		}
+	// an empty default can't be combined with a kong enum, the order is validated here instead
+	if r.UpdateOrder != "" && r.UpdateOrder != swarm.UpdateOrderStopFirst && r.UpdateOrder != swarm.UpdateOrderStartFirst {
+		return errors.Errorf("invalid update order %q, expected stop-first or start-first", r.UpdateOrder)
+	}
 
-		scheduleEnv := fmt.Sprintf("UPDATE_ID=%s", r.ScheduleId)
-		if foundIndex != -1 {
-			config.Env[foundIndex] = scheduleEnv
-		} else {
-			config.Env = append(config.Env, scheduleEnv)
-		}
+	options := dockerswarm.UpdateOptions{
+		Order:   r.UpdateOrder,
+		Monitor: r.UpdateMonitor,
+		Timeout: r.Timeout,
 
-		if config.Labels == nil {
-			config.Labels = make(map[string]string)
-		}
+		StackPolicy:       r.StackPolicy,
+		PrintStackSnippet: r.StackSnippet,
+	}
 
-		config.Labels[UpdateScheduleIDLabel] = r.ScheduleId
+	return dockerswarm.Update(ctx, dockerCli, r.Image, service, options, func(config *swarm.ContainerSpec) {
+		config.Env = r.setUpdateIDEnv(config.Env)
+		config.Labels = r.setScheduleIDLabel(config.Labels)
 	})
 }
 
-func (r *AgentCommand) runNomad(ctx context.Context) error {
-	nomadConfig := api.DefaultConfig()
-
-	nomadAddress := os.Getenv(nomad.NomadAddrEnvVarName)
-	if strings.HasPrefix(nomadAddress, "https") {
-		tls := &api.TLSConfig{
-			CACertPEM:     []byte(os.Getenv(nomad.NomadCACertContentEnvVarName)),
-			ClientCertPEM: []byte(os.Getenv(nomad.NomadClientCertContentEnvVarName)),
-			ClientKeyPEM:  []byte(os.Getenv(nomad.NomadClientKeyContentEnvVarName)),
+func (r *AgentCommand) setUpdateIDEnv(env []string) []string {
+	foundIndex := -1
+	for index, value := range env {
+		if strings.HasPrefix(value, "UPDATE_ID=") {
+			foundIndex = index
 		}
-		nomadConfig.TLSConfig = tls
 	}
 
-	nomadCli, err := api.NewClient(nomadConfig)
+	scheduleEnv := fmt.Sprintf("UPDATE_ID=%s", r.ScheduleId)
+	if foundIndex != -1 {
+		env[foundIndex] = scheduleEnv
+	} else {
+		env = append(env, scheduleEnv)
+	}
+
+	return env
+}
+
+func (r *AgentCommand) setScheduleIDLabel(labels map[string]string) map[string]string {
+	if labels == nil {
+		labels = make(map[string]string)
+	}
+
+	labels[UpdateScheduleIDLabel] = r.ScheduleId
+
+	return labels
+}
+
+func (r *AgentCommand) runNomad(ctx context.Context) error {
+	nomadCli, err := nomad.GetClient()
 	if err != nil {
-		return errors.WithMessage(err, "failed to initialize Nomad client")
+		return err
 	}
 
-	job, task, err := nomad.FindAgentContainer(ctx, nomadCli)
+	agentJobs, err := nomad.FindAgentJobs(ctx, nomadCli, nomad.FindOptions{
+		Namespace:     r.NomadNamespace,
+		JobID:         r.NomadJob,
+		ImagePrefixes: r.AgentImagePrefix,
+	})
 	if err != nil {
 		return errors.WithMessage(err, "failed finding container id")
 	}
 
+	options := nomad.UpdateOptions{
+		Timeout:         r.Timeout,
+		MaxParallel:     r.NomadMaxParallel,
+		HealthCheck:     r.NomadHealthCheck,
+		MinHealthyTime:  r.NomadMinHealthyTime,
+		HealthyDeadline: r.NomadHealthyDeadline,
+		AutoRevert:      r.NomadAutoRevert,
+		Canary:          r.NomadCanary,
+	}
+
+	failures := 0
+	for _, agentJob := range agentJobs {
+		err := r.updateNomadJob(ctx, nomadCli, agentJob, options)
+		if err != nil {
+			log.Err(err).
+				Str("job", *agentJob.Job.ID).
+				Str("namespace", *agentJob.Job.Namespace).
+				Msg("Unable to update agent job")
+
+			failures++
+		}
+	}
+
+	if failures > 0 {
+		return errors.Errorf("failed to update %d of %d agent jobs", failures, len(agentJobs))
+	}
+
+	return nil
+}
+
+func (r *AgentCommand) updateNomadJob(ctx context.Context, nomadCli *api.Client, agentJob nomad.AgentJob, options nomad.UpdateOptions) error {
+	options.SecretEnv = make(map[string]string, len(nomad.SensitiveEnvVarNames))
+	for _, name := range nomad.SensitiveEnvVarNames {
+		options.SecretEnv[name] = os.Getenv(name)
+	}
+
+	for _, task := range agentJob.Tasks {
+		r.setNomadTaskEnv(task)
+
+		for _, name := range nomad.SensitiveEnvVarNames {
+			// values previously written in clear text are moved to the job variable
+			if options.SecretEnv[name] == "" {
+				options.SecretEnv[name] = task.Env[name]
+			}
+		}
+	}
+
+	return nomad.Update(ctx, nomadCli, agentJob.Job, agentJob.Tasks, r.Image, r.ScheduleId, options)
+}
+
+// setNomadTaskEnv sets the agent env of the task, the values missing from the updater env are kept from the existing task,
+// the sensitive values are stored in the job variable by the update
+func (r *AgentCommand) setNomadTaskEnv(task *api.Task) {
 	if task.Env == nil {
 		task.Env = make(map[string]string, 0)
 	}
 
-	// add nomad env
-	task.Env[nomad.NomadAddrEnvVarName] = os.Getenv(nomad.NomadAddrEnvVarName)
-	task.Env[nomad.NomadNamespaceEnvVarName] = os.Getenv(nomad.NomadNamespaceEnvVarName)
-	task.Env[nomad.NomadRegionEnvVarName] = os.Getenv(nomad.NomadRegionEnvVarName)
-	task.Env[nomad.NomadTokenEnvVarName] = os.Getenv(nomad.NomadTokenEnvVarName)
-	// add nomad tls certificate info env
-	task.Env[nomad.NomadCACertContentEnvVarName] = os.Getenv(nomad.NomadCACertContentEnvVarName)
-	task.Env[nomad.NomadClientCertContentEnvVarName] = os.Getenv(nomad.NomadClientCertContentEnvVarName)
-	task.Env[nomad.NomadClientKeyContentEnvVarName] = os.Getenv(nomad.NomadClientKeyContentEnvVarName)
-	// add portainer agent env
-	task.Env[nomad.EnvKeyEdge] = os.Getenv(nomad.EnvKeyEdge)
-	task.Env[nomad.EnvKeyEdgeKey] = os.Getenv(nomad.EnvKeyEdgeKey)
-	task.Env[nomad.EnvKeyEdgeID] = os.Getenv(nomad.EnvKeyEdgeID)
-	task.Env[nomad.EnvKeyEdgeInsecurePoll] = os.Getenv(nomad.EnvKeyEdgeInsecurePoll)
-	task.Env[nomad.EnvKeyAgentSecret] = os.Getenv(nomad.EnvKeyAgentSecret)
+	for _, name := range []string{
+		// nomad env
+		nomad.NomadAddrEnvVarName,
+		nomad.NomadNamespaceEnvVarName,
+		nomad.NomadRegionEnvVarName,
+		// nomad tls certificate info env
+		nomad.NomadCACertContentEnvVarName,
+		nomad.NomadClientCertContentEnvVarName,
+		// portainer agent env
+		nomad.EnvKeyEdge,
+		nomad.EnvKeyEdgeID,
+		nomad.EnvKeyEdgeInsecurePoll,
+	} {
+		if value := os.Getenv(name); value != "" {
+			task.Env[name] = value
+		}
+	}
+
 	// add update id
 	task.Env[nomad.EnvKeyUpdateID] = r.ScheduleId
-
-	return nomad.Update(ctx, nomadCli, job, task, r.Image, r.ScheduleId)
 }
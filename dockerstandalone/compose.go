@@ -0,0 +1,205 @@
+package dockerstandalone
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// composeLabelPrefix prefixes the labels docker compose uses to track the containers of a project
+	composeLabelPrefix = "com.docker.compose."
+
+	composeProjectLabel     = "com.docker.compose.project"
+	composeServiceLabel     = "com.docker.compose.service"
+	composeConfigFilesLabel = "com.docker.compose.project.config_files"
+)
+
+func isComposeManaged(labels map[string]string) bool {
+	return labels[composeProjectLabel] != ""
+}
+
+// removeComposeLabels removes the compose labels copied from the old container. The config hash can only be computed
+// by compose from the whole service config, a drifting hash would make the next docker compose up recreate the container
+// from the compose file and silently revert the update, so the new container is left out of the compose project instead
+func removeComposeLabels(labels map[string]string) {
+	log.Warn().
+		Str("project", labels[composeProjectLabel]).
+		Str("service", labels[composeServiceLabel]).
+		Msg("Removing the docker compose labels from the new container, remove it before the next docker compose up to manage it with compose again")
+
+	for key := range labels {
+		if strings.HasPrefix(key, composeLabelPrefix) {
+			delete(labels, key)
+		}
+	}
+}
+
+func updateComposeFile(labels map[string]string, imageName string, options UpdateOptions) {
+	if !options.RewriteComposeFile {
+		log.Warn().
+			Str("project", labels[composeProjectLabel]).
+			Str("service", labels[composeServiceLabel]).
+			Str("files", labels[composeConfigFilesLabel]).
+			Str("image", imageName).
+			Msg("Container is managed by docker compose and the compose file still references the previous image, the next docker compose up will revert this update. Update the image in the compose file or use --compose-rewrite")
+		return
+	}
+
+	err := rewriteComposeFiles(labels, imageName, options.ComposeFile)
+	if err != nil {
+		log.Err(err).
+			Str("project", labels[composeProjectLabel]).
+			Str("service", labels[composeServiceLabel]).
+			Msg("Unable to update the compose file, please update its image manually")
+	}
+}
+
+// rewriteComposeFiles replaces the image of the compose service in its compose files,
+// composeFile overrides the files listed in the container labels when set
+func rewriteComposeFiles(labels map[string]string, imageName, composeFile string) error {
+	service := labels[composeServiceLabel]
+
+	files := strings.Split(labels[composeConfigFilesLabel], ",")
+	if composeFile != "" {
+		files = []string{composeFile}
+	}
+
+	rewritten := false
+	for _, file := range files {
+		if file == "" {
+			continue
+		}
+
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return errors.WithMessagef(err, "unable to read compose file %s, make sure it is mounted in the updater container", file)
+		}
+
+		newContent, found, err := rewriteComposeImage(content, service, imageName)
+		if err != nil {
+			return errors.WithMessagef(err, "unable to update compose file %s", file)
+		}
+
+		if !found {
+			continue
+		}
+
+		info, err := os.Stat(file)
+		if err != nil {
+			return errors.WithMessagef(err, "unable to stat compose file %s", file)
+		}
+
+		err = os.WriteFile(file, newContent, info.Mode())
+		if err != nil {
+			return errors.WithMessagef(err, "unable to write compose file %s", file)
+		}
+
+		log.Info().
+			Str("file", file).
+			Str("service", service).
+			Str("image", imageName).
+			Msg("Compose file updated")
+
+		rewritten = true
+	}
+
+	if !rewritten {
+		return errors.Errorf("no image field found for service %s in the compose files", service)
+	}
+
+	return nil
+}
+
+// rewriteComposeImage replaces the image field of the service in the compose file content.
+// The file is parsed to locate the field and only its value is replaced in the content to keep the formatting and comments,
+// the files the replacement can't be made safely in (multiple documents, anchors, multi-line values) are refused
+func rewriteComposeImage(content []byte, service, imageName string) ([]byte, bool, error) {
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+
+	var document yaml.Node
+	err := decoder.Decode(&document)
+	if err == io.EOF {
+		return content, false, nil
+	}
+	if err != nil {
+		return nil, false, errors.WithMessage(err, "unable to parse compose file")
+	}
+
+	var next yaml.Node
+	if err := decoder.Decode(&next); err != io.EOF {
+		return nil, false, errors.New("compose files with multiple documents are not supported")
+	}
+
+	if len(document.Content) == 0 {
+		return content, false, nil
+	}
+
+	services := mappingValue(document.Content[0], "services")
+	if services == nil {
+		return content, false, nil
+	}
+
+	serviceNode := mappingValue(services, service)
+	if serviceNode == nil {
+		return content, false, nil
+	}
+
+	if serviceNode.Kind == yaml.AliasNode || serviceNode.Anchor != "" || mappingValue(serviceNode, "<<") != nil {
+		return nil, false, errors.Errorf("service %s uses YAML anchors or aliases, update its image manually", service)
+	}
+
+	image := mappingValue(serviceNode, "image")
+	if image == nil {
+		return content, false, nil
+	}
+
+	if image.Kind != yaml.ScalarNode || image.Anchor != "" {
+		return nil, false, errors.Errorf("the image of service %s uses YAML anchors or aliases, update it manually", service)
+	}
+
+	var raw, replacement string
+	switch image.Style {
+	case 0:
+		raw, replacement = image.Value, imageName
+	case yaml.DoubleQuotedStyle:
+		raw, replacement = `"`+image.Value+`"`, `"`+imageName+`"`
+	case yaml.SingleQuotedStyle:
+		raw, replacement = `'`+image.Value+`'`, `'`+imageName+`'`
+	default:
+		return nil, false, errors.Errorf("the image of service %s spans multiple lines, update it manually", service)
+	}
+
+	lines := strings.Split(string(content), "\n")
+	line := lines[image.Line-1]
+	column := image.Column - 1
+
+	// the value found at the node position differs when it spans multiple lines or uses escapes
+	if column > len(line) || !strings.HasPrefix(line[column:], raw) {
+		return nil, false, errors.Errorf("the image of service %s spans multiple lines, update it manually", service)
+	}
+
+	lines[image.Line-1] = line[:column] + replacement + line[column+len(raw):]
+
+	return []byte(strings.Join(lines, "\n")), true, nil
+}
+
+// mappingValue returns the value of the key in the mapping node, nil when the node isn't a mapping or the key is missing
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node.Kind != yaml.MappingNode {
+		return nil
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,161 @@
+package dockerstandalone
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRewriteComposeImage(t *testing.T) {
+	tests := []struct {
+		name      string
+		content   string
+		service   string
+		want      string
+		wantFound bool
+		wantErr   bool
+	}{
+		{
+			name: "replaces the service image",
+			content: `version: "3"
+services:
+  agent:
+    image: portainer/agent:2.18.1 # pinned
+    restart: always
+  portainer:
+    image: portainer/portainer-ee:2.18.1
+`,
+			service: "portainer",
+			want: `version: "3"
+services:
+  agent:
+    image: portainer/agent:2.18.1 # pinned
+    restart: always
+  portainer:
+    image: portainer/portainer-ee:2.19.0
+`,
+			wantFound: true,
+		},
+		{
+			name: "ignores nested keys named like the service",
+			content: `services:
+  agent:
+    networks:
+      portainer:
+    image: portainer/agent:2.18.1
+`,
+			service: "portainer",
+			want: `services:
+  agent:
+    networks:
+      portainer:
+    image: portainer/agent:2.18.1
+`,
+			wantFound: false,
+		},
+		{
+			name: "service without image",
+			content: `services:
+  portainer:
+    build: .
+volumes:
+  portainer:
+    image: not-a-service
+`,
+			service: "portainer",
+			want: `services:
+  portainer:
+    build: .
+volumes:
+  portainer:
+    image: not-a-service
+`,
+			wantFound: false,
+		},
+		{
+			name: "keeps quotes",
+			content: `services:
+  portainer:
+    image: "portainer/portainer-ee:2.18.1"
+`,
+			service: "portainer",
+			want: `services:
+  portainer:
+    image: "portainer/portainer-ee:2.19.0"
+`,
+			wantFound: true,
+		},
+		{
+			name: "refuses multiple documents",
+			content: `services:
+  portainer:
+    image: portainer/portainer-ee:2.18.1
+---
+services:
+  agent:
+    image: portainer/agent:2.18.1
+`,
+			service: "portainer",
+			wantErr: true,
+		},
+		{
+			name: "refuses anchors",
+			content: `x-portainer: &portainer
+  image: portainer/portainer-ee:2.18.1
+services:
+  portainer:
+    <<: *portainer
+    image: portainer/portainer-ee:2.18.1
+`,
+			service: "portainer",
+			wantErr: true,
+		},
+		{
+			name: "refuses multi-line image",
+			content: `services:
+  portainer:
+    image: >-
+      portainer/portainer-ee:2.18.1
+`,
+			service: "portainer",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, found, err := rewriteComposeImage([]byte(tt.content), tt.service, "portainer/portainer-ee:2.19.0")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("rewriteComposeImage() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if tt.wantErr {
+				return
+			}
+
+			if found != tt.wantFound {
+				t.Errorf("rewriteComposeImage() found = %v, want %v", found, tt.wantFound)
+			}
+
+			if string(got) != tt.want {
+				t.Errorf("rewriteComposeImage() = %v, want %v", string(got), tt.want)
+			}
+		})
+	}
+}
+
+func TestRemoveComposeLabels(t *testing.T) {
+	labels := map[string]string{
+		"com.docker.compose.project":          "portainer",
+		"com.docker.compose.service":          "portainer",
+		"com.docker.compose.config-hash":      "8e1f7d7c",
+		"com.docker.compose.container-number": "1",
+		"io.portainer.server":                 "true",
+	}
+
+	removeComposeLabels(labels)
+
+	want := map[string]string{"io.portainer.server": "true"}
+	if !reflect.DeepEqual(labels, want) {
+		t.Errorf("removeComposeLabels() = %v, want %v", labels, want)
+	}
+}
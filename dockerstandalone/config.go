@@ -0,0 +1,102 @@
+package dockerstandalone
+
+import (
+	"reflect"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/go-connections/nat"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// userConfig returns a copy of the container configuration without the settings inherited from its image,
+// the new container gets the defaults of the new image for every setting the user didn't specify
+func userConfig(config *container.Config, imageConfig *container.Config) *container.Config {
+	userConfig := *config
+	if imageConfig == nil {
+		return &userConfig
+	}
+
+	userConfig.Env = withoutImageValues(config.Env, imageConfig.Env)
+
+	userConfig.Labels = map[string]string{}
+	for key, value := range config.Labels {
+		if imageValue, ok := imageConfig.Labels[key]; !ok || imageValue != value {
+			userConfig.Labels[key] = value
+		}
+	}
+
+	// the image command is only reset by Docker when the entrypoint is overridden
+	if reflect.DeepEqual(config.Entrypoint, imageConfig.Entrypoint) {
+		userConfig.Entrypoint = nil
+
+		if reflect.DeepEqual(config.Cmd, imageConfig.Cmd) {
+			userConfig.Cmd = nil
+		}
+	}
+
+	if config.Healthcheck != nil && reflect.DeepEqual(config.Healthcheck, imageConfig.Healthcheck) {
+		userConfig.Healthcheck = nil
+	}
+
+	if config.WorkingDir == imageConfig.WorkingDir {
+		userConfig.WorkingDir = ""
+	}
+
+	if config.User == imageConfig.User {
+		userConfig.User = ""
+	}
+
+	if config.StopSignal == imageConfig.StopSignal {
+		userConfig.StopSignal = ""
+	}
+
+	if config.ExposedPorts != nil {
+		userConfig.ExposedPorts = nat.PortSet{}
+		for port := range config.ExposedPorts {
+			if _, ok := imageConfig.ExposedPorts[port]; !ok {
+				userConfig.ExposedPorts[port] = struct{}{}
+			}
+		}
+	}
+
+	if config.Volumes != nil {
+		userConfig.Volumes = map[string]struct{}{}
+		for volume := range config.Volumes {
+			if _, ok := imageConfig.Volumes[volume]; !ok {
+				userConfig.Volumes[volume] = struct{}{}
+			}
+		}
+	}
+
+	return &userConfig
+}
+
+func withoutImageValues(values, imageValues []string) []string {
+	inherited := make(map[string]bool, len(imageValues))
+	for _, value := range imageValues {
+		inherited[value] = true
+	}
+
+	var userValues []string
+	for _, value := range values {
+		if !inherited[value] {
+			userValues = append(userValues, value)
+		}
+	}
+
+	return userValues
+}
+
+// imagePlatform returns the platform of the image, nil when it's unknown
+func imagePlatform(image *types.ImageInspect) *ocispec.Platform {
+	if image == nil || image.Os == "" || image.Architecture == "" {
+		return nil
+	}
+
+	return &ocispec.Platform{
+		OS:           image.Os,
+		Architecture: image.Architecture,
+		Variant:      image.Variant,
+	}
+}
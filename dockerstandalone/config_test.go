@@ -0,0 +1,89 @@
+package dockerstandalone
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/go-connections/nat"
+)
+
+func TestUserConfig(t *testing.T) {
+	imageConfig := &container.Config{
+		Env:          []string{"PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"},
+		Labels:       map[string]string{"org.opencontainers.image.version": "2.18.1"},
+		Entrypoint:   []string{"./agent"},
+		WorkingDir:   "/app",
+		StopSignal:   "SIGTERM",
+		ExposedPorts: nat.PortSet{"9001/tcp": {}},
+		Volumes:      map[string]struct{}{"/data": {}},
+		Healthcheck:  &container.HealthConfig{Test: []string{"CMD", "./agent", "health"}},
+	}
+
+	tests := []struct {
+		name        string
+		config      *container.Config
+		imageConfig *container.Config
+		want        *container.Config
+	}{
+		{
+			name: "inherited settings are dropped",
+			config: &container.Config{
+				Env:          []string{"PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin", "AGENT_SECRET=secret"},
+				Labels:       map[string]string{"org.opencontainers.image.version": "2.18.1", "io.portainer.agent": "true"},
+				Entrypoint:   []string{"./agent"},
+				WorkingDir:   "/app",
+				StopSignal:   "SIGTERM",
+				ExposedPorts: nat.PortSet{"9001/tcp": {}},
+				Volumes:      map[string]struct{}{"/data": {}},
+				Healthcheck:  &container.HealthConfig{Test: []string{"CMD", "./agent", "health"}},
+			},
+			imageConfig: imageConfig,
+			want: &container.Config{
+				Env:          []string{"AGENT_SECRET=secret"},
+				Labels:       map[string]string{"io.portainer.agent": "true"},
+				ExposedPorts: nat.PortSet{},
+				Volumes:      map[string]struct{}{},
+			},
+		},
+		{
+			name: "user settings are kept",
+			config: &container.Config{
+				Env:          []string{"PATH=/opt/bin"},
+				Labels:       map[string]string{"org.opencontainers.image.version": "custom"},
+				Entrypoint:   []string{"/bin/sh", "-c"},
+				Cmd:          []string{"./agent"},
+				WorkingDir:   "/srv",
+				StopSignal:   "SIGINT",
+				ExposedPorts: nat.PortSet{"9001/tcp": {}, "80/tcp": {}},
+				Healthcheck:  &container.HealthConfig{Test: []string{"NONE"}},
+			},
+			imageConfig: imageConfig,
+			want: &container.Config{
+				Env:          []string{"PATH=/opt/bin"},
+				Labels:       map[string]string{"org.opencontainers.image.version": "custom"},
+				Entrypoint:   []string{"/bin/sh", "-c"},
+				Cmd:          []string{"./agent"},
+				WorkingDir:   "/srv",
+				StopSignal:   "SIGINT",
+				ExposedPorts: nat.PortSet{"80/tcp": {}},
+				Healthcheck:  &container.HealthConfig{Test: []string{"NONE"}},
+			},
+		},
+		{
+			name:        "unknown image",
+			config:      &container.Config{Env: []string{"PATH=/bin"}, StopSignal: "SIGTERM"},
+			imageConfig: nil,
+			want:        &container.Config{Env: []string{"PATH=/bin"}, StopSignal: "SIGTERM"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := userConfig(tt.config, tt.imageConfig)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("userConfig() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
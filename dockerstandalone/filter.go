@@ -0,0 +1,48 @@
+package dockerstandalone
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// FilterCandidates keeps the candidates matching every filter, a filter is either
+// label=<key>, label=<key>=<value> or name=<part of the container name>
+func FilterCandidates(candidates []Candidate, filters []string) ([]Candidate, error) {
+	var matchers []func(Candidate) bool
+	for _, filter := range filters {
+		kind, value, ok := strings.Cut(filter, "=")
+		if !ok || value == "" {
+			return nil, errors.Errorf("invalid filter %q, expected label=<key>[=<value>] or name=<name>", filter)
+		}
+
+		switch kind {
+		case "label":
+			key, expected, hasValue := strings.Cut(value, "=")
+			matchers = append(matchers, func(c Candidate) bool {
+				actual, ok := c.Container.Labels[key]
+				return ok && (!hasValue || actual == expected)
+			})
+		case "name":
+			matchers = append(matchers, func(c Candidate) bool {
+				return strings.Contains(containerName(c.Container), value)
+			})
+		default:
+			return nil, errors.Errorf("invalid filter %q, expected label=<key>[=<value>] or name=<name>", filter)
+		}
+	}
+
+	var filtered []Candidate
+	for _, candidate := range candidates {
+		matches := true
+		for _, matcher := range matchers {
+			matches = matches && matcher(candidate)
+		}
+
+		if matches {
+			filtered = append(filtered, candidate)
+		}
+	}
+
+	return filtered, nil
+}
@@ -0,0 +1,64 @@
+package dockerstandalone
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+)
+
+func TestFilterCandidates(t *testing.T) {
+	agent := Candidate{Container: types.Container{ID: "a", Names: []string{"/portainer_agent"}, Labels: map[string]string{"io.portainer.agent": "true"}}}
+	edgeAgent := Candidate{Container: types.Container{ID: "b", Names: []string{"/portainer_edge_agent"}, Labels: map[string]string{"io.portainer.agent": "true", "instance": "edge"}}}
+	candidates := []Candidate{agent, edgeAgent}
+
+	tests := []struct {
+		name    string
+		filters []string
+		want    []Candidate
+		wantErr bool
+	}{
+		{
+			name: "no filter",
+			want: candidates,
+		},
+		{
+			name:    "label key",
+			filters: []string{"label=instance"},
+			want:    []Candidate{edgeAgent},
+		},
+		{
+			name:    "label value",
+			filters: []string{"label=io.portainer.agent=true"},
+			want:    candidates,
+		},
+		{
+			name:    "name",
+			filters: []string{"name=edge"},
+			want:    []Candidate{edgeAgent},
+		},
+		{
+			name:    "every filter must match",
+			filters: []string{"name=portainer", "label=instance=other"},
+			want:    nil,
+		},
+		{
+			name:    "invalid filter",
+			filters: []string{"image=portainer/agent"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := FilterCandidates(candidates, tt.filters)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("FilterCandidates() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("FilterCandidates() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
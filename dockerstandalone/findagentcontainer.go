@@ -5,31 +5,23 @@ import (
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/client"
-	"github.com/pkg/errors"
-	"github.com/rs/zerolog/log"
 )
 
-func FindAgentContainer(ctx context.Context, dockerCli *client.Client) (*types.Container, error) {
-	queries := []findContainerQuery{
-		{findByLabelFn("io.portainer.agent=true"), "findByLabel"},
-		{findByImageFn("portainer/agent", "portainerci/agent"), "findByImage"},
-		{findByLogsFn("Starting Agent API server"), "findByLogs"},
+// FindAgentContainer returns the agent container, it fails when several containers match with the same confidence.
+// imagePrefixes are additional image prefixes identifying an agent container
+func FindAgentContainer(ctx context.Context, dockerCli *client.Client, imagePrefixes ...string) (*types.Container, error) {
+	candidates, err := FindAgentContainers(ctx, dockerCli, imagePrefixes...)
+	if err != nil {
+		return nil, err
 	}
 
-	for _, query := range queries {
-		container, err := query.fn(ctx, dockerCli)
-		if err != nil {
-			return nil, errors.WithMessagef(err, "failed finding container %s", query.name)
-		}
+	return selectCandidate(candidates)
+}
 
-		if container != nil {
-			log.Debug().
-				Str("container", container.ID).
-				Str("query", query.name).
-				Msg("Found container")
-			return container, nil
-		}
-	}
+// FindAgentContainers returns the running containers matching an agent, sorted by decreasing confidence
+func FindAgentContainers(ctx context.Context, dockerCli *client.Client, imagePrefixes ...string) ([]Candidate, error) {
+	d := agentDiscovery
+	d.imagePrefixes = append(agentImagePrefixes, imagePrefixes...)
 
-	return nil, errors.New("unable to find container")
+	return findCandidates(ctx, dockerCli, d)
 }
@@ -0,0 +1,80 @@
+package dockerstandalone
+
+import (
+	"context"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/client"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	agentImagePrefixes     = []string{"portainer/agent", "portainerci/agent"}
+	portainerImagePrefixes = []string{"portainer/portainer", "portainerci/portainer"}
+)
+
+// FindAgentContainerByName returns the running container matching the ID or name,
+// it must run an agent image or be labeled as an agent
+func FindAgentContainerByName(ctx context.Context, dockerCli *client.Client, idOrName string, imagePrefixes ...string) (*types.Container, error) {
+	return findContainerByName(ctx, dockerCli, idOrName, "io.portainer.agent", append(agentImagePrefixes, imagePrefixes...))
+}
+
+// FindPortainerContainerByName returns the running container matching the ID or name,
+// it must run a Portainer image or be labeled as a Portainer server
+func FindPortainerContainerByName(ctx context.Context, dockerCli *client.Client, idOrName string) (*types.Container, error) {
+	return findContainerByName(ctx, dockerCli, idOrName, "io.portainer.server", portainerImagePrefixes)
+}
+
+func findContainerByName(ctx context.Context, dockerCli *client.Client, idOrName, label string, imagePrefixes []string) (*types.Container, error) {
+	inspect, err := dockerCli.ContainerInspect(ctx, idOrName)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "unable to inspect container %s", idOrName)
+	}
+
+	if inspect.State == nil || !inspect.State.Running {
+		return nil, errors.Errorf("container %s is not running", idOrName)
+	}
+
+	if inspect.Config.Labels[label] != "true" && !hasImagePrefix(inspect.Config.Image, imagePrefixes) {
+		return nil, errors.Errorf("container %s runs the image %s, expected one of %s or the %s=true label", idOrName, inspect.Config.Image, strings.Join(imagePrefixes, ", "), label)
+	}
+
+	filters := filters.NewArgs()
+	filters.Add("id", inspect.ID)
+
+	containers, err := dockerCli.ContainerList(ctx, container.ListOptions{
+		Filters: filters,
+	})
+	if err != nil {
+		return nil, errors.WithMessage(err, "unable to list containers")
+	}
+
+	if len(containers) != 1 {
+		return nil, errors.Errorf("container %s not found", idOrName)
+	}
+
+	log.Debug().
+		Str("container", inspect.ID).
+		Str("name", idOrName).
+		Str("image", inspect.Config.Image).
+		Msg("Found container")
+
+	return &containers[0], nil
+}
+
+// hasImagePrefix matches the image against the prefixes, ignoring the default registry
+func hasImagePrefix(image string, prefixes []string) bool {
+	image = strings.TrimPrefix(image, "docker.io/")
+
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(image, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
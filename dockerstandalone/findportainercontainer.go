@@ -5,31 +5,14 @@ import (
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/client"
-	"github.com/pkg/errors"
-	"github.com/rs/zerolog/log"
 )
 
+// FindPortainerContainer returns the Portainer container, it fails when several containers match with the same confidence
 func FindPortainerContainer(ctx context.Context, dockerCli *client.Client) (*types.Container, error) {
-	queries := []findContainerQuery{
-		{findByLabelFn("io.portainer.server=true"), "findByLabel"},
-		{findByImageFn("portainer/portainer", "portainerci/portainer"), "findByImage"},
-		{findByLogsFn("starting Portainer"), "findByLogs"},
+	candidates, err := findCandidates(ctx, dockerCli, portainerDiscovery)
+	if err != nil {
+		return nil, err
 	}
 
-	for _, query := range queries {
-		container, err := query.fn(ctx, dockerCli)
-		if err != nil {
-			return nil, errors.WithMessagef(err, "failed finding container %s", query.name)
-		}
-
-		if container != nil {
-			log.Debug().
-				Str("container", container.ID).
-				Str("query", query.name).
-				Msg("Found container")
-			return container, nil
-		}
-	}
-
-	return nil, errors.New("unable to find container")
+	return selectCandidate(candidates)
 }
@@ -0,0 +1,11 @@
+package dockerstandalone
+
+// UpdateOptions holds the settings of the container recreation
+type UpdateOptions struct {
+	// RewriteComposeFile replaces the image of the service in its compose file when the container is managed by docker compose
+	RewriteComposeFile bool
+	// ComposeFile is the path of the compose file in the updater container, the files listed in the compose labels are used when empty
+	ComposeFile string
+	// Podman adapts the recreation to the Docker compatible API of Podman, it's detected from the server version when false
+	Podman bool
+}
@@ -0,0 +1,53 @@
+package dockerstandalone
+
+import (
+	"context"
+	"strings"
+
+	"github.com/docker/docker/client"
+	"github.com/pkg/errors"
+)
+
+// isPodman returns true when the Docker API is served by Podman, which lists itself in the version components
+func isPodman(ctx context.Context, dockerCli *client.Client) (bool, error) {
+	version, err := dockerCli.ServerVersion(ctx)
+	if err != nil {
+		return false, errors.WithMessage(err, "unable to get server version")
+	}
+
+	for _, component := range version.Components {
+		if strings.Contains(strings.ToLower(component.Name), "podman") {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// imageID returns the ID of the local image, empty when the image isn't available
+func imageID(ctx context.Context, dockerCli *client.Client, imageName string) string {
+	image, _, err := dockerCli.ImageInspectWithRaw(ctx, imageName)
+	if err != nil {
+		return ""
+	}
+
+	return image.ID
+}
+
+// attachedNetworks returns the networks the container is connected to,
+// Podman connects the container to every network of the create request
+func attachedNetworks(ctx context.Context, dockerCli *client.Client, containerID string) (map[string]bool, error) {
+	container, err := dockerCli.ContainerInspect(ctx, containerID)
+	if err != nil {
+		return nil, errors.WithMessage(err, "unable to inspect container")
+	}
+
+	attached := make(map[string]bool)
+	if container.NetworkSettings != nil {
+		for networkName := range container.NetworkSettings.Networks {
+			attached[networkName] = true
+		}
+	}
+
+	return attached, nil
+}
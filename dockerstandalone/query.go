@@ -3,108 +3,266 @@ package dockerstandalone
 import (
 	"bufio"
 	"context"
+	"fmt"
+	"os"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/client"
 	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
 )
 
-type queryFn = func(context.Context, *client.Client) (*types.Container, error)
+// confidence scores of the discovery matches, the highest match of a container is kept
+const (
+	scoreLabel         = 100
+	scoreImageMetadata = 80
+	scoreImageName     = 60
+	scoreLogs          = 30
+)
+
+const (
+	ociTitleLabel  = "org.opencontainers.image.title"
+	ociVendorLabel = "org.opencontainers.image.vendor"
+
+	// logsWindow bounds the logs read after the container start when looking for its startup message
+	logsWindow = 2 * time.Minute
+	// logsMaxLines bounds the log lines scanned, the tail is only requested when the start time is unknown
+	// as the daemon applies it before the since and until filters
+	logsMaxLines = 1000
+)
+
+// updaterImagePrefixes are excluded from the discovery as they match the Portainer image prefixes
+var updaterImagePrefixes = []string{"portainer/portainer-updater", "portainerci/portainer-updater"}
 
-type findContainerQuery struct {
-	fn   queryFn
-	name string
+// Candidate is a running container matching the discovery with the confidence of the match
+type Candidate struct {
+	Container types.Container
+	Score     int
+	Reason    string
 }
 
-func findByLabelFn(label string) queryFn {
-	return func(ctx context.Context, dockerCli *client.Client) (*types.Container, error) {
-		filters := filters.NewArgs()
-		filters.Add("status", "running")
-		filters.Add("label", label)
-
-		containers, err := dockerCli.ContainerList(ctx, container.ListOptions{
-			Filters: filters,
-		})
-		if err != nil {
-			return nil, errors.WithMessage(err, "unable to list containers")
+// discovery describes how to recognize the containers of a Portainer component
+type discovery struct {
+	// label is the label set to true on the containers
+	label string
+	// imagePrefixes are the repositories of the images
+	imagePrefixes []string
+	// imageTitles are the org.opencontainers.image.title values of the images
+	imageTitles []string
+	// logMessage is written by the container on startup
+	logMessage string
+}
+
+var (
+	agentDiscovery = discovery{
+		label:         "io.portainer.agent",
+		imagePrefixes: agentImagePrefixes,
+		imageTitles:   []string{"portainer agent"},
+		logMessage:    "Starting Agent API server",
+	}
+
+	portainerDiscovery = discovery{
+		label:         "io.portainer.server",
+		imagePrefixes: portainerImagePrefixes,
+		imageTitles:   []string{"portainer", "portainer ce", "portainer ee", "portainer business"},
+		logMessage:    "starting Portainer",
+	}
+)
+
+// findCandidates returns the running containers matching the discovery, sorted by decreasing score.
+// The logs are only read when no container matches by label or image
+func findCandidates(ctx context.Context, dockerCli *client.Client, d discovery) ([]Candidate, error) {
+	filters := filters.NewArgs()
+	filters.Add("status", "running")
+
+	containers, err := dockerCli.ContainerList(ctx, container.ListOptions{
+		Filters: filters,
+	})
+	if err != nil {
+		return nil, errors.WithMessage(err, "unable to list containers")
+	}
+
+	hostname, _ := os.Hostname()
+	images := make(map[string]*types.ImageInspect)
+
+	var others []types.Container
+	var matches []Candidate
+	for _, c := range containers {
+		if isSelf(c, hostname) || hasImagePrefix(c.Image, updaterImagePrefixes) {
+			continue
 		}
 
-		if len(containers) == 0 {
-			return nil, nil
+		image, ok := images[c.ImageID]
+		if !ok {
+			inspect, _, err := dockerCli.ImageInspectWithRaw(ctx, c.ImageID)
+			if err != nil {
+				log.Debug().
+					Err(err).
+					Str("image", c.Image).
+					Msg("Unable to inspect image")
+			} else {
+				image = &inspect
+			}
+
+			images[c.ImageID] = image
 		}
 
-		if len(containers) > 1 {
-			return nil, errors.New("multiple containers found")
+		score, reason := d.score(c, image)
+		if score == 0 {
+			others = append(others, c)
+			continue
 		}
 
-		return &containers[0], nil
+		matches = append(matches, Candidate{Container: c, Score: score, Reason: reason})
+	}
+
+	if len(matches) == 0 {
+		for _, c := range others {
+			found, err := logsContain(ctx, dockerCli, c.ID, d.logMessage)
+			if err != nil {
+				return nil, err
+			}
+
+			if found {
+				matches = append(matches, Candidate{Container: c, Score: scoreLogs, Reason: "logs"})
+			}
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].Score > matches[j].Score
+	})
+
+	for _, match := range matches {
+		log.Debug().
+			Str("container", match.Container.ID).
+			Strs("names", match.Container.Names).
+			Int("score", match.Score).
+			Str("reason", match.Reason).
+			Msg("Found candidate container")
 	}
-}
 
-func findByImageFn(possibleImagePrefixes ...string) queryFn {
-	return func(ctx context.Context, dockerCli *client.Client) (*types.Container, error) {
-		filters := filters.NewArgs()
-		filters.Add("status", "running")
+	return matches, nil
+}
 
-		// not using the ancestor filter because it looks for latest tag
+// score returns the confidence of the container matching the discovery, 0 when it doesn't match
+func (d discovery) score(c types.Container, image *types.ImageInspect) (int, string) {
+	if c.Labels[d.label] == "true" {
+		return scoreLabel, "label"
+	}
 
-		containers, err := dockerCli.ContainerList(ctx, container.ListOptions{
-			Filters: filters,
-		})
-		if err != nil {
-			return nil, errors.WithMessage(err, "unable to list containers")
+	if image != nil {
+		if image.Config != nil && d.hasImageTitle(image.Config.Labels) {
+			return scoreImageMetadata, "image labels"
 		}
 
-		for _, container := range containers {
-			for _, possibleImage := range possibleImagePrefixes {
-				if strings.HasPrefix(container.Image, possibleImage) {
-					return &container, nil
-				}
+		for _, digest := range image.RepoDigests {
+			if hasImagePrefix(digest, d.imagePrefixes) {
+				return scoreImageMetadata, "image digest"
 			}
 		}
+	}
 
-		return nil, nil
+	if hasImagePrefix(c.Image, d.imagePrefixes) {
+		return scoreImageName, "image name"
 	}
-}
 
-func findByLogsFn(log string) queryFn {
+	return 0, ""
+}
 
-	return func(ctx context.Context, dockerCli *client.Client) (*types.Container, error) {
-		filters := filters.NewArgs()
-		filters.Add("status", "running")
+func (d discovery) hasImageTitle(labels map[string]string) bool {
+	if !strings.Contains(strings.ToLower(labels[ociVendorLabel]), "portainer") {
+		return false
+	}
 
-		containers, err := dockerCli.ContainerList(ctx, container.ListOptions{
-			Filters: filters,
-		})
-		if err != nil {
-			return nil, errors.WithMessage(err, "unable to list containers")
+	title := strings.ToLower(labels[ociTitleLabel])
+	for _, expected := range d.imageTitles {
+		if title == expected {
+			return true
 		}
+	}
 
-		for _, c := range containers {
-			logs, err := dockerCli.ContainerLogs(ctx, c.ID, container.LogsOptions{
-				ShowStdout: true,
-				ShowStderr: true,
-			})
-			if err != nil {
-				return nil, errors.WithMessage(err, "unable to get container logs")
-			}
+	return false
+}
 
-			scanner := bufio.NewScanner(logs)
+// selectCandidate returns the container with the highest score, the discovery fails when several containers share it
+func selectCandidate(candidates []Candidate) (*types.Container, error) {
+	if len(candidates) == 0 {
+		return nil, errors.New("unable to find container")
+	}
 
-			for scanner.Scan() {
-				if strings.Contains(scanner.Text(), log) {
-					return &c, nil
-				}
+	if len(candidates) > 1 && candidates[1].Score == candidates[0].Score {
+		var names []string
+		for _, candidate := range candidates {
+			if candidate.Score != candidates[0].Score {
+				break
 			}
 
-			if err := scanner.Err(); err != nil {
-				return nil, errors.WithMessage(err, "unable to read container logs")
-			}
+			names = append(names, fmt.Sprintf("%s (%s)", containerName(candidate.Container), candidate.Reason))
+		}
+
+		return nil, errors.Errorf("multiple containers found: %s, select one with --container", strings.Join(names, ", "))
+	}
+
+	return &candidates[0].Container, nil
+}
+
+// isSelf returns true for the updater container, its hostname defaults to the short container ID
+func isSelf(c types.Container, hostname string) bool {
+	return len(hostname) >= 12 && strings.HasPrefix(c.ID, hostname)
+}
+
+func containerName(c types.Container) string {
+	if len(c.Names) > 0 {
+		return strings.TrimPrefix(c.Names[0], "/")
+	}
+
+	return c.ID
+}
+
+// logsContain looks for the message in the logs written right after the container start
+func logsContain(ctx context.Context, dockerCli *client.Client, containerID, message string) (bool, error) {
+	options := container.LogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Tail:       fmt.Sprint(logsMaxLines),
+	}
+
+	inspect, err := dockerCli.ContainerInspect(ctx, containerID)
+	if err != nil {
+		return false, errors.WithMessage(err, "unable to inspect container")
+	}
+
+	if inspect.State != nil {
+		startedAt, err := time.Parse(time.RFC3339Nano, inspect.State.StartedAt)
+		if err == nil {
+			options.Since = fmt.Sprint(startedAt.Unix())
+			options.Until = fmt.Sprint(startedAt.Add(logsWindow).Unix())
+			options.Tail = ""
+		}
+	}
+
+	logs, err := dockerCli.ContainerLogs(ctx, containerID, options)
+	if err != nil {
+		return false, errors.WithMessage(err, "unable to get container logs")
+	}
+	defer logs.Close()
+
+	scanner := bufio.NewScanner(logs)
+	for lines := 0; lines < logsMaxLines && scanner.Scan(); lines++ {
+		if strings.Contains(scanner.Text(), message) {
+			return true, nil
 		}
+	}
 
-		return nil, nil
+	if err := scanner.Err(); err != nil {
+		return false, errors.WithMessage(err, "unable to read container logs")
 	}
+
+	return false, nil
 }
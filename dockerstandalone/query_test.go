@@ -0,0 +1,116 @@
+package dockerstandalone
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+)
+
+func TestDiscoveryScore(t *testing.T) {
+	tests := []struct {
+		name       string
+		container  types.Container
+		image      *types.ImageInspect
+		wantScore  int
+		wantReason string
+	}{
+		{
+			name:       "label",
+			container:  types.Container{Image: "registry.example.com/agent:2.19.0", Labels: map[string]string{"io.portainer.agent": "true"}},
+			wantScore:  scoreLabel,
+			wantReason: "label",
+		},
+		{
+			name:      "image labels",
+			container: types.Container{Image: "registry.example.com/agent:2.19.0"},
+			image: &types.ImageInspect{Config: &container.Config{Labels: map[string]string{
+				ociVendorLabel: "Portainer.io",
+				ociTitleLabel:  "Portainer Agent",
+			}}},
+			wantScore:  scoreImageMetadata,
+			wantReason: "image labels",
+		},
+		{
+			name:       "repo digest",
+			container:  types.Container{Image: "sha256:0123456789ab"},
+			image:      &types.ImageInspect{RepoDigests: []string{"docker.io/portainer/agent@sha256:0123456789ab"}},
+			wantScore:  scoreImageMetadata,
+			wantReason: "image digest",
+		},
+		{
+			name:       "image name",
+			container:  types.Container{Image: "portainer/agent:2.19.0"},
+			wantScore:  scoreImageName,
+			wantReason: "image name",
+		},
+		{
+			name:      "other vendor title",
+			container: types.Container{Image: "nginx:latest"},
+			image: &types.ImageInspect{Config: &container.Config{Labels: map[string]string{
+				ociVendorLabel: "Example",
+				ociTitleLabel:  "Portainer Agent",
+			}}},
+			wantScore: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			score, reason := agentDiscovery.score(tt.container, tt.image)
+			if score != tt.wantScore || reason != tt.wantReason {
+				t.Errorf("score() = %d, %q, want %d, %q", score, reason, tt.wantScore, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestSelectCandidate(t *testing.T) {
+	tests := []struct {
+		name       string
+		candidates []Candidate
+		wantID     string
+		wantErr    bool
+		wantErrMsg string
+	}{
+		{
+			name:    "no candidate",
+			wantErr: true,
+		},
+		{
+			name: "highest score",
+			candidates: []Candidate{
+				{Container: types.Container{ID: "a"}, Score: scoreLabel},
+				{Container: types.Container{ID: "b"}, Score: scoreImageName},
+			},
+			wantID: "a",
+		},
+		{
+			name: "ambiguous",
+			candidates: []Candidate{
+				{Container: types.Container{ID: "a", Names: []string{"/portainer"}}, Score: scoreImageName, Reason: "image name"},
+				{Container: types.Container{ID: "b", Names: []string{"/portainer-old"}}, Score: scoreImageName, Reason: "image name"},
+				{Container: types.Container{ID: "c"}, Score: scoreLogs, Reason: "logs"},
+			},
+			wantErr:    true,
+			wantErrMsg: "multiple containers found: portainer (image name), portainer-old (image name), select one with --container",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := selectCandidate(tt.candidates)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("selectCandidate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if tt.wantErrMsg != "" && err.Error() != tt.wantErrMsg {
+				t.Errorf("selectCandidate() error = %v, want %v", err, tt.wantErrMsg)
+			}
+
+			if !tt.wantErr && got.ID != tt.wantID {
+				t.Errorf("selectCandidate() = %s, want %s", got.ID, tt.wantID)
+			}
+		})
+	}
+}
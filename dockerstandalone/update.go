@@ -16,14 +16,17 @@ import (
 	"github.com/docker/docker/api/types/image"
 	"github.com/docker/docker/api/types/network"
 	"github.com/docker/docker/api/types/registry"
+	"github.com/docker/docker/api/types/versions"
 	"github.com/docker/docker/client"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog/log"
 )
 
 var errUpdateFailure = errors.New("update failure")
 
-func Update(ctx context.Context, dockerCli *client.Client, oldContainerId string, imageName string, updateConfig func(*container.Config)) error {
+// Update recreates the container with the new image, it returns false when the image is already up to date
+func Update(ctx context.Context, dockerCli *client.Client, oldContainerId string, imageName string, options UpdateOptions, updateConfig func(*container.Config)) (bool, error) {
 	log.Info().
 		Str("containerId", oldContainerId).
 		Str("image", imageName).
@@ -41,7 +44,20 @@ func Update(ctx context.Context, dockerCli *client.Client, oldContainerId string
 			Str("containerId", oldContainerId).
 			Msg("Unable to inspect container")
 
-		return errUpdateFailure
+		return false, errUpdateFailure
+	}
+
+	if !options.Podman {
+		options.Podman, err = isPodman(ctx, dockerCli)
+		if err != nil {
+			log.Warn().
+				Err(err).
+				Msg("Unable to detect Podman, assuming Docker")
+		}
+	}
+
+	if options.Podman {
+		log.Info().Msg("Podman detected, using the Podman update mode")
 	}
 
 	log.Debug().
@@ -49,12 +65,12 @@ func Update(ctx context.Context, dockerCli *client.Client, oldContainerId string
 		Str("containerImage", oldContainer.Config.Image).
 		Msg("Checking whether the latest image is available")
 
-	imageUpToDate, err := pullImage(ctx, dockerCli, imageName)
+	imageUpToDate, err := pullImage(ctx, dockerCli, imageName, options.Podman)
 	if err != nil {
 		log.Err(err).
 			Msg("Unable to pull image")
 
-		return errUpdateFailure
+		return false, errUpdateFailure
 	}
 
 	if oldContainer.Config.Image == imageName && imageUpToDate {
@@ -63,39 +79,90 @@ func Update(ctx context.Context, dockerCli *client.Client, oldContainerId string
 			Str("containerId", oldContainerId).
 			Msg("Image is already up to date, shutting down")
 
-		return nil
+		return false, nil
 	}
 
 	oldContainerName := strings.TrimPrefix(oldContainer.Name, "/")
 
-	// We create the new container
-	tempContainerName := buildContainerName(oldContainerName)
+	// the labels are copied as the old container configuration is reused for the new container
+	composeLabels := map[string]string{}
+	if isComposeManaged(oldContainer.Config.Labels) {
+		for key, value := range oldContainer.Config.Labels {
+			composeLabels[key] = value
+		}
+	}
 
-	newContainerID, err := createContainer(ctx, dockerCli, imageName, tempContainerName, oldContainer, updateConfig)
+	// Podman refuses to rename a running container, the old container is stopped before the rename instead of before the start
+	if options.Podman {
+		err = stopContainer(ctx, dockerCli, oldContainer.ID)
+		if err != nil {
+			log.Err(err).
+				Str("containerId", oldContainerId).
+				Msg("Unable to stop old container")
+
+			restartOldContainer(ctx, dockerCli, oldContainer.ID)
+
+			return false, errUpdateFailure
+		}
+	}
+
+	// the old container is renamed out of the way so the new container is created under the original name
+	backupContainerName := buildBackupContainerName(oldContainerName)
+
+	log.Debug().
+		Str("containerId", oldContainerId).
+		Str("containerName", backupContainerName).
+		Msg("Renaming old container")
+
+	err = dockerCli.ContainerRename(ctx, oldContainer.ID, backupContainerName)
+	if err != nil {
+		log.Err(err).
+			Str("containerId", oldContainerId).
+			Msg("Unable to rename old container")
+
+		if options.Podman {
+			restartOldContainer(ctx, dockerCli, oldContainer.ID)
+		}
+
+		return false, errUpdateFailure
+	}
+
+	// We create the new container
+	newContainerID, err := createContainer(ctx, dockerCli, imageName, oldContainerName, oldContainer, options.Podman, updateConfig)
 	if err != nil {
 		log.Err(err).
 			Msg("Unable to create container")
 
-		return cleanupContainerAndError(ctx, dockerCli, oldContainerId, newContainerID)
+		return false, cleanupContainerAndError(ctx, dockerCli, oldContainer.ID, oldContainerName, newContainerID)
+	}
+
+	if !options.Podman {
+		err = stopContainer(ctx, dockerCli, oldContainer.ID)
+		if err != nil {
+			log.Err(err).
+				Msg("Unable to stop old container")
+
+			return false, cleanupContainerAndError(ctx, dockerCli, oldContainer.ID, oldContainerName, newContainerID)
+		}
 	}
 
-	err = startContainer(ctx, dockerCli, oldContainer.ID, newContainerID)
+	err = startContainer(ctx, dockerCli, newContainerID)
 	if err != nil {
 		log.Err(err).
 			Msg("Unable to start container")
 
-		return cleanupContainerAndError(ctx, dockerCli, oldContainerId, newContainerID)
+		return false, cleanupContainerAndError(ctx, dockerCli, oldContainer.ID, oldContainerName, newContainerID)
 	}
 
-	healthy, err := monitorHealth(ctx, dockerCli, newContainerID)
+	healthy, err := monitorHealth(ctx, dockerCli, newContainerID, options.Podman)
 	if err != nil {
 		log.Err(err).
 			Msg("Unable to monitor container health")
-		return cleanupContainerAndError(ctx, dockerCli, oldContainerId, newContainerID)
+		return false, cleanupContainerAndError(ctx, dockerCli, oldContainer.ID, oldContainerName, newContainerID)
 	}
 
 	if !healthy {
-		return cleanupContainerAndError(ctx, dockerCli, oldContainerId, newContainerID)
+		return false, cleanupContainerAndError(ctx, dockerCli, oldContainer.ID, oldContainerName, newContainerID)
 	}
 
 	log.Info().
@@ -103,12 +170,8 @@ func Update(ctx context.Context, dockerCli *client.Client, oldContainerId string
 
 	tryRemoveOldContainer(ctx, dockerCli, oldContainer.ID)
 
-	// rename new container to old container name
-	err = dockerCli.ContainerRename(ctx, newContainerID, oldContainerName)
-	if err != nil {
-		log.Err(err).
-			Msg("Unable to rename container")
-		return nil
+	if isComposeManaged(composeLabels) {
+		updateComposeFile(composeLabels, imageName, options)
 	}
 
 	log.Info().
@@ -117,43 +180,59 @@ func Update(ctx context.Context, dockerCli *client.Client, oldContainerId string
 		Str("containerName", oldContainerName).
 		Msg("Update process completed")
 
-	return nil
+	return true, nil
 }
 
-func cleanupContainerAndError(ctx context.Context, dockerCli *client.Client, oldContainerId, newContainerID string) error {
+// cleanupContainerAndError removes the new container and restores the name of the old container before restarting it,
+// the returned error reports the old container left under its backup name when the rename fails
+func cleanupContainerAndError(ctx context.Context, dockerCli *client.Client, oldContainerId, oldContainerName, newContainerID string) error {
 	log.Debug().
 		Msg("An error occurred during the update process - removing newly created container")
 
-	// should restart old container
-	err := dockerCli.ContainerStart(ctx, oldContainerId, container.StartOptions{})
-	if err != nil {
-		log.Err(err).
-			Str("containerId", oldContainerId).
-			Msg("Unable to restart container, please restart it manually")
-	}
-
 	if newContainerID != "" {
 		printLogsToStdout(ctx, dockerCli, newContainerID)
 
-		err = dockerCli.ContainerRemove(ctx, newContainerID, container.RemoveOptions{Force: true})
+		err := dockerCli.ContainerRemove(ctx, newContainerID, container.RemoveOptions{Force: true})
 		if err != nil {
 			log.Err(err).
-				Msg("Unable to remove temporary container, please remove it manually")
+				Msg("Unable to remove new container, please remove it manually")
 		}
 	}
 
+	renameErr := dockerCli.ContainerRename(ctx, oldContainerId, oldContainerName)
+	if renameErr != nil {
+		log.Err(renameErr).
+			Str("containerId", oldContainerId).
+			Str("containerName", oldContainerName).
+			Msg("Unable to restore the container name, please rename it manually")
+	}
+
+	restartOldContainer(ctx, dockerCli, oldContainerId)
+
+	if renameErr != nil {
+		// no container runs under the original name anymore
+		return errors.WithMessagef(errUpdateFailure, "the old container %s is left without its name %s: %s", oldContainerId, oldContainerName, renameErr)
+	}
+
 	return errUpdateFailure
 }
 
-func buildContainerName(containerName string) string {
-	if strings.HasSuffix(containerName, "-update") {
-		return strings.TrimSuffix(containerName, "-update")
+// restartOldContainer starts the old container again after a failed update, starting a running container does nothing
+func restartOldContainer(ctx context.Context, dockerCli *client.Client, oldContainerId string) {
+	err := dockerCli.ContainerStart(ctx, oldContainerId, container.StartOptions{})
+	if err != nil {
+		log.Err(err).
+			Str("containerId", oldContainerId).
+			Msg("Unable to restart container, please restart it manually")
 	}
+}
 
-	return fmt.Sprintf("%s-update", containerName)
+// buildBackupContainerName returns a unique name for the old container during the update
+func buildBackupContainerName(containerName string) string {
+	return fmt.Sprintf("%s-old-%d", containerName, time.Now().Unix())
 }
 
-func pullImage(ctx context.Context, dockerCli *client.Client, imageName string) (bool, error) {
+func pullImage(ctx context.Context, dockerCli *client.Client, imageName string, podman bool) (bool, error) {
 	if os.Getenv("SKIP_PULL") != "" {
 		return false, nil
 	}
@@ -174,6 +253,12 @@ func pullImage(ctx context.Context, dockerCli *client.Client, imageName string)
 		imagePullOptions.RegistryAuth = base64.URLEncoding.EncodeToString(encodedJSON)
 	}
 
+	// Podman doesn't report up to date images in the pull output, the image IDs are compared instead
+	previousImageID := ""
+	if podman {
+		previousImageID = imageID(ctx, dockerCli, imageName)
+	}
+
 	log.Debug().
 		Str("image", imageName).
 		Msg("Pulling Docker image")
@@ -196,21 +281,28 @@ func pullImage(ctx context.Context, dockerCli *client.Client, imageName string)
 	io.Copy(os.Stdout, tee)
 	io.Copy(&imagePullOutputBuf, reader)
 
+	if podman {
+		return previousImageID != "" && previousImageID == imageID(ctx, dockerCli, imageName), nil
+	}
+
 	// TODO: REVIEW
 	// There might be a cleaner way to check whether the container is using the same image as the one available locally
 	// Maybe through image digest validation instead of checking the output of the docker pull command
 	return strings.Contains(imagePullOutputBuf.String(), "Image is up to date"), nil
 }
 
-func copyContainerConfig(imageName string, config *container.Config, containerNetworks map[string]*network.EndpointSettings) (newConfig *container.Config, networks []string, networkConfig *network.NetworkingConfig) {
+func copyContainerConfig(imageName string, config *container.Config, imageConfig *container.Config, containerNetworks map[string]*network.EndpointSettings) (newConfig *container.Config, networks []string, networkConfig *network.NetworkingConfig) {
 	// We copy the original Portainer configuration and apply a few changes:
+	// * we drop the settings inherited from the old image so the new image defaults apply
 	// * we replace the image name
 	// * we strip the hostname from the original configuration to avoid networking issues with the internal Docker DNS
-	// * we remove the original container healthcheck as we should use the one embedded in the target version image
-	containerConfigCopy := config
+	// * we remove the original container healthcheck when the old image is unknown as we should use the one embedded in the target version image
+	containerConfigCopy := userConfig(config, imageConfig)
 	containerConfigCopy.Image = imageName
 	containerConfigCopy.Hostname = ""
-	containerConfigCopy.Healthcheck = nil
+	if imageConfig == nil {
+		containerConfigCopy.Healthcheck = nil
+	}
 
 	// We add the new container in the same Docker container networks as the previous container
 	// This configuration is copied to the new container configuration
@@ -226,14 +318,27 @@ func copyContainerConfig(imageName string, config *container.Config, containerNe
 	}
 }
 
-func applyNetworks(ctx context.Context, dockerCli *client.Client, containerID string, networks []string) error {
+func applyNetworks(ctx context.Context, dockerCli *client.Client, containerID string, networks []string, podman bool) error {
 	// We have to join all the networks one by one after container creation
 	log.Debug().
 		Str("containerId", containerID).
 		Interface("networks", networks).
 		Msg("Joining container to Docker networks")
 
+	attached := map[string]bool{}
+	if podman {
+		var err error
+		attached, err = attachedNetworks(ctx, dockerCli, containerID)
+		if err != nil {
+			return err
+		}
+	}
+
 	for _, networkName := range networks {
+		if attached[networkName] {
+			continue
+		}
+
 		err := dockerCli.NetworkConnect(ctx, networkName, containerID, nil)
 		if err != nil {
 			return err
@@ -255,7 +360,7 @@ func tryRemoveOldContainer(ctx context.Context, dockerCli *client.Client, oldCon
 	}
 }
 
-func monitorHealth(ctx context.Context, dockerCli *client.Client, containerId string) (bool, error) {
+func monitorHealth(ctx context.Context, dockerCli *client.Client, containerId string, podman bool) (bool, error) {
 	// We then wait for the new container to be ready and monitor its health
 	// This is done by inspecting the container healthcheck status
 	log.Debug().
@@ -270,7 +375,8 @@ func monitorHealth(ctx context.Context, dockerCli *client.Client, containerId st
 		return false, errors.WithMessage(err, "Unable to inspect new container")
 	}
 
-	if container.State.Health == nil {
+	// Podman reports an empty health status for containers without health check
+	if container.State.Health == nil || (podman && container.State.Health.Status == "") {
 		if container.State.Status == "exited" {
 			return false, errors.New("Container exited unexpectedly")
 		}
@@ -311,6 +417,16 @@ func monitorHealth(ctx context.Context, dockerCli *client.Client, containerId st
 		}
 	}
 
+	// Podman runs health checks with systemd timers, they never run on hosts without systemd
+	if podman && len(container.State.Health.Log) == 0 && container.State.Running {
+		log.Warn().
+			Str("containerId", containerId).
+			Str("status", container.State.Health.Status).
+			Msg("Podman did not run the container health check, assuming health check passed")
+
+		return true, nil
+	}
+
 	log.Error().
 		Str("status", container.State.Health.Status).
 		Interface("logs", container.State.Health.Log).
@@ -320,18 +436,26 @@ func monitorHealth(ctx context.Context, dockerCli *client.Client, containerId st
 
 }
 
-func startContainer(ctx context.Context, dockerCli *client.Client, oldContainerID, newContainerID string) error {
-	// We then start the new container
+func stopContainer(ctx context.Context, dockerCli *client.Client, oldContainerID string) error {
 	log.Debug().
-		Str("containerId", newContainerID).
-		Msg("Starting new container")
+		Str("containerId", oldContainerID).
+		Msg("Stopping old container")
 
 	err := dockerCli.ContainerStop(ctx, oldContainerID, container.StopOptions{})
 	if err != nil {
 		return errors.WithMessage(err, "Unable to stop old container")
 	}
 
-	err = dockerCli.ContainerStart(ctx, newContainerID, container.StartOptions{})
+	return nil
+}
+
+func startContainer(ctx context.Context, dockerCli *client.Client, newContainerID string) error {
+	// We then start the new container
+	log.Debug().
+		Str("containerId", newContainerID).
+		Msg("Starting new container")
+
+	err := dockerCli.ContainerStart(ctx, newContainerID, container.StartOptions{})
 	if err != nil {
 		return errors.WithMessage(err, "Unable to start new container")
 	}
@@ -339,28 +463,50 @@ func startContainer(ctx context.Context, dockerCli *client.Client, oldContainerI
 	return nil
 }
 
-func createContainer(ctx context.Context, dockerCli *client.Client, imageName, tempContainerName string, oldContainer types.ContainerJSON, updateConfig func(*container.Config)) (string, error) {
+func createContainer(ctx context.Context, dockerCli *client.Client, imageName, containerName string, oldContainer types.ContainerJSON, podman bool, updateConfig func(*container.Config)) (string, error) {
 	log.Debug().
-		Str("containerName", tempContainerName).
+		Str("containerName", containerName).
 		Str("image", imageName).
 		Msg("Creating new container")
 
-	containerConfigCopy, networks, networkConfig := copyContainerConfig(imageName, oldContainer.Config, oldContainer.NetworkSettings.Networks)
+	var imageConfig *container.Config
+	var platform *ocispec.Platform
+
+	oldImage, _, err := dockerCli.ImageInspectWithRaw(ctx, oldContainer.Image)
+	if err != nil {
+		log.Warn().
+			Err(err).
+			Str("image", oldContainer.Image).
+			Msg("Unable to inspect the image of the old container, copying its whole configuration")
+	} else {
+		imageConfig = oldImage.Config
+
+		// the platform is only supported from API version 1.41
+		if versions.GreaterThanOrEqualTo(dockerCli.ClientVersion(), "1.41") {
+			platform = imagePlatform(&oldImage)
+		}
+	}
+
+	containerConfigCopy, networks, networkConfig := copyContainerConfig(imageName, oldContainer.Config, imageConfig, oldContainer.NetworkSettings.Networks)
 
 	updateConfig(containerConfigCopy)
 
+	if isComposeManaged(containerConfigCopy.Labels) {
+		removeComposeLabels(containerConfigCopy.Labels)
+	}
+
 	newContainer, err := dockerCli.ContainerCreate(ctx,
 		containerConfigCopy,
 		oldContainer.HostConfig,
 		networkConfig,
-		nil,
-		tempContainerName,
+		platform,
+		containerName,
 	)
 	if err != nil {
 		return "", errors.WithMessage(err, "Unable to create new container")
 	}
 
-	err = applyNetworks(ctx, dockerCli, newContainer.ID, networks)
+	err = applyNetworks(ctx, dockerCli, newContainer.ID, networks, podman)
 	if err != nil {
 		return newContainer.ID, errors.WithMessage(err, "Unable to join container to network")
 	}
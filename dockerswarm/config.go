@@ -0,0 +1,103 @@
+package dockerswarm
+
+import (
+	"context"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/docker/docker/client"
+	"github.com/rs/zerolog/log"
+)
+
+// UpdateOptions holds the settings the updater applies on top of the service update config
+type UpdateOptions struct {
+	// Order is the order of operations when replacing a task, either stop-first or start-first
+	Order string
+	// Monitor is the duration after each task update to monitor for failure, the service setting is kept when zero
+	Monitor time.Duration
+	// Timeout is the maximum duration to wait for the update to complete
+	Timeout time.Duration
+	// StackPolicy defines how a service deployed as part of a stack is handled
+	StackPolicy StackPolicy
+	// PrintStackSnippet prints the stack file changes matching the update to stdout
+	PrintStackSnippet bool
+	// StackEnv holds the environment entries added to the stack file snippet
+	StackEnv []string
+}
+
+// mergeUpdateConfig keeps the service update config (parallelism, delay, max failure ratio...)
+// and only overrides the settings required by the updater
+func mergeUpdateConfig(current *swarm.UpdateConfig, options UpdateOptions) *swarm.UpdateConfig {
+	merged := swarm.UpdateConfig{}
+	if current != nil {
+		merged = *current
+	}
+
+	merged.FailureAction = swarm.UpdateFailureActionRollback
+
+	if options.Order != "" {
+		merged.Order = options.Order
+	}
+
+	if options.Monitor > 0 {
+		merged.Monitor = options.Monitor
+	}
+
+	return &merged
+}
+
+// mergeRollbackConfig keeps the service rollback config and only fills the settings it doesn't define,
+// so a failed update is rolled back the same way it was applied
+func mergeRollbackConfig(current *swarm.UpdateConfig, options UpdateOptions) *swarm.UpdateConfig {
+	merged := swarm.UpdateConfig{}
+	if current != nil {
+		merged = *current
+	}
+
+	if merged.Order == "" {
+		merged.Order = options.Order
+	}
+
+	if merged.Monitor == 0 {
+		merged.Monitor = options.Monitor
+	}
+
+	return &merged
+}
+
+// restoreUpdateConfig puts back the update and rollback config the service had before the update,
+// changing them doesn't redeploy the service tasks
+func restoreUpdateConfig(ctx context.Context, dockerCli *client.Client, serviceID string, updateConfig, rollbackConfig *swarm.UpdateConfig) {
+	log.Debug().
+		Str("serviceId", serviceID).
+		Msg("Restoring service update config")
+
+	service, _, err := dockerCli.ServiceInspectWithRaw(ctx, serviceID, types.ServiceInspectOptions{})
+	if err != nil {
+		log.Err(err).
+			Str("serviceId", serviceID).
+			Msg("Unable to inspect service, please restore its update config manually")
+		return
+	}
+
+	service.Spec.UpdateConfig = updateConfig
+	service.Spec.RollbackConfig = rollbackConfig
+
+	_, err = dockerCli.ServiceUpdate(ctx, service.ID, service.Meta.Version, service.Spec, types.ServiceUpdateOptions{})
+	if err != nil {
+		log.Err(err).
+			Str("serviceId", serviceID).
+			Msg("Unable to restore service update config, please restore it manually")
+	}
+}
+
+// logOriginalUpdateConfig prints the update and rollback config the service had before the update
+// when they can't be restored safely
+func logOriginalUpdateConfig(serviceID string, updateConfig, rollbackConfig *swarm.UpdateConfig) {
+	log.Warn().
+		Str("serviceId", serviceID).
+		Interface("updateConfig", updateConfig).
+		Interface("rollbackConfig", rollbackConfig).
+		Msg("The service update config was not restored, please restore it manually once the update is settled")
+}
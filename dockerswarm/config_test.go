@@ -0,0 +1,65 @@
+package dockerswarm
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/swarm"
+)
+
+func TestMergeUpdateConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		current *swarm.UpdateConfig
+		options UpdateOptions
+		want    *swarm.UpdateConfig
+	}{
+		{
+			name:    "no update config",
+			current: nil,
+			options: UpdateOptions{Order: swarm.UpdateOrderStopFirst},
+			want: &swarm.UpdateConfig{
+				FailureAction: swarm.UpdateFailureActionRollback,
+				Order:         swarm.UpdateOrderStopFirst,
+			},
+		},
+		{
+			name: "keeps user settings",
+			current: &swarm.UpdateConfig{
+				Parallelism:     2,
+				Delay:           10 * time.Second,
+				FailureAction:   swarm.UpdateFailureActionPause,
+				Monitor:         30 * time.Second,
+				MaxFailureRatio: 0.2,
+				Order:           swarm.UpdateOrderStopFirst,
+			},
+			options: UpdateOptions{Order: swarm.UpdateOrderStartFirst},
+			want: &swarm.UpdateConfig{
+				Parallelism:     2,
+				Delay:           10 * time.Second,
+				FailureAction:   swarm.UpdateFailureActionRollback,
+				Monitor:         30 * time.Second,
+				MaxFailureRatio: 0.2,
+				Order:           swarm.UpdateOrderStartFirst,
+			},
+		},
+		{
+			name:    "overrides monitor period",
+			current: &swarm.UpdateConfig{Monitor: 30 * time.Second},
+			options: UpdateOptions{Monitor: time.Minute},
+			want: &swarm.UpdateConfig{
+				FailureAction: swarm.UpdateFailureActionRollback,
+				Monitor:       time.Minute,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mergeUpdateConfig(tt.current, tt.options); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("mergeUpdateConfig() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
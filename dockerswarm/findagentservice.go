@@ -0,0 +1,40 @@
+package dockerswarm
+
+import (
+	"context"
+
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/docker/docker/client"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+var agentImagePrefixes = []string{"portainer/agent", "portainerci/agent"}
+
+// FindAgentService looks for the agent service in the swarm, imagePrefixes are additional image prefixes
+// identifying the agent, e.g. the repository of a registry mirror
+func FindAgentService(ctx context.Context, dockerCli *client.Client, imagePrefixes ...string) (*swarm.Service, error) {
+	prefixes := append(agentImagePrefixes, imagePrefixes...)
+
+	queries := []findServiceQuery{
+		{findByLabelFn("io.portainer.agent=true"), "findByLabel"},
+		{findByImageFn(func(image string) bool { return hasImagePrefix(image, prefixes) }), "findByImage"},
+	}
+
+	for _, query := range queries {
+		service, err := query.fn(ctx, dockerCli)
+		if err != nil {
+			return nil, errors.WithMessagef(err, "failed finding service %s", query.name)
+		}
+
+		if service != nil {
+			log.Debug().
+				Str("service", service.ID).
+				Str("query", query.name).
+				Msg("Found service")
+			return service, nil
+		}
+	}
+
+	return nil, errors.New("unable to find service")
+}
@@ -12,7 +12,48 @@ import (
 	"github.com/rs/zerolog/log"
 )
 
-func FindPortainerService(ctx context.Context, dockerCli *client.Client) (*swarm.Service, error) {
+var portainerRepositories = []string{
+	"portainer/portainer-ce", "portainer/portainer-ee", "portainer/portainer",
+	"portainerci/portainer-ce", "portainerci/portainer-ee", "portainerci/portainer",
+}
+
+// FindPortainerService looks for the Portainer service in the swarm, serviceName (a service name or ID) takes precedence over the discovery
+func FindPortainerService(ctx context.Context, dockerCli *client.Client, serviceName string) (*swarm.Service, error) {
+	if serviceName != "" {
+		service, _, err := dockerCli.ServiceInspectWithRaw(ctx, serviceName, types.ServiceInspectOptions{})
+		if err != nil {
+			return nil, errors.WithMessagef(err, "unable to inspect service %s", serviceName)
+		}
+
+		return &service, nil
+	}
+
+	queries := []findServiceQuery{
+		{findByLabelFn("io.portainer.server=true"), "findByLabel"},
+		{findByImageFn(func(image string) bool { return hasImageRepository(image, portainerRepositories) }), "findByImage"},
+		// requires the Portainer task to run on the node of the updater, so it's only used as a last resort
+		{findByLocalContainer, "findByLocalContainer"},
+	}
+
+	for _, query := range queries {
+		service, err := query.fn(ctx, dockerCli)
+		if err != nil {
+			return nil, errors.WithMessagef(err, "failed finding service %s", query.name)
+		}
+
+		if service != nil {
+			log.Debug().
+				Str("service", service.ID).
+				Str("query", query.name).
+				Msg("Found service")
+			return service, nil
+		}
+	}
+
+	return nil, errors.New("unable to find service")
+}
+
+func findByLocalContainer(ctx context.Context, dockerCli *client.Client) (*swarm.Service, error) {
 	container, err := dockerstandalone.FindPortainerContainer(ctx, dockerCli)
 	if err != nil {
 		return nil, err
@@ -43,7 +84,7 @@ func FindPortainerService(ctx context.Context, dockerCli *client.Client) (*swarm
 	}
 
 	if len(services) > 1 {
-		return nil, errors.New("multiple services found")
+		return nil, errors.Errorf("multiple services found for %s", serviceName)
 	}
 
 	return &services[0], nil
@@ -0,0 +1,123 @@
+package dockerswarm
+
+import (
+	"context"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/docker/docker/client"
+	"github.com/pkg/errors"
+)
+
+type queryFn = func(context.Context, *client.Client) (*swarm.Service, error)
+
+type findServiceQuery struct {
+	fn   queryFn
+	name string
+}
+
+func findByLabelFn(label string) queryFn {
+	return func(ctx context.Context, dockerCli *client.Client) (*swarm.Service, error) {
+		filters := filters.NewArgs()
+		filters.Add("label", label)
+
+		services, err := dockerCli.ServiceList(ctx, types.ServiceListOptions{
+			Filters: filters,
+		})
+		if err != nil {
+			return nil, errors.WithMessage(err, "unable to list services")
+		}
+
+		// plugin and runtime services have no container spec to update
+		var matches []swarm.Service
+		for _, service := range services {
+			if service.Spec.TaskTemplate.ContainerSpec != nil {
+				matches = append(matches, service)
+			}
+		}
+
+		if len(matches) == 0 {
+			return nil, nil
+		}
+
+		if len(matches) > 1 {
+			return nil, errors.New("multiple services found")
+		}
+
+		return &matches[0], nil
+	}
+}
+
+// findByImageFn looks for the service running an image accepted by matchImage, it fails when several services match
+func findByImageFn(matchImage func(image string) bool) queryFn {
+	return func(ctx context.Context, dockerCli *client.Client) (*swarm.Service, error) {
+		services, err := dockerCli.ServiceList(ctx, types.ServiceListOptions{})
+		if err != nil {
+			return nil, errors.WithMessage(err, "unable to list services")
+		}
+
+		var matches []swarm.Service
+		for _, service := range services {
+			if service.Spec.TaskTemplate.ContainerSpec == nil {
+				continue
+			}
+
+			if matchImage(service.Spec.TaskTemplate.ContainerSpec.Image) {
+				matches = append(matches, service)
+			}
+		}
+
+		if len(matches) == 0 {
+			return nil, nil
+		}
+
+		if len(matches) > 1 {
+			var names []string
+			for _, service := range matches {
+				names = append(names, service.Spec.Name)
+			}
+
+			return nil, errors.Errorf("multiple services run the image: %s", strings.Join(names, ", "))
+		}
+
+		return &matches[0], nil
+	}
+}
+
+// hasImageRepository returns true when the repository of the image is one of the repositories,
+// the exact match keeps the updater image (portainer/portainer-updater) from being mistaken for the Portainer image
+func hasImageRepository(image string, repositories []string) bool {
+	repository := imageRepository(image)
+	for _, expected := range repositories {
+		if repository == expected {
+			return true
+		}
+	}
+
+	return false
+}
+
+// imageRepository returns the repository of the image without the default registry, the tag and the digest
+func imageRepository(image string) string {
+	repository := strings.TrimPrefix(imageWithoutDigest(image), "docker.io/")
+
+	if i := strings.LastIndex(repository, ":"); i > strings.LastIndex(repository, "/") {
+		repository = repository[:i]
+	}
+
+	return repository
+}
+
+func hasImagePrefix(image string, prefixes []string) bool {
+	image = strings.TrimPrefix(image, "docker.io/")
+
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(image, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
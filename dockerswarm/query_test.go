@@ -0,0 +1,47 @@
+package dockerswarm
+
+import "testing"
+
+func TestHasImageRepository(t *testing.T) {
+	tests := []struct {
+		image string
+		want  bool
+	}{
+		{image: "portainer/portainer-ee:2.19.0", want: true},
+		{image: "docker.io/portainer/portainer-ce:latest@sha256:0123456789ab", want: true},
+		{image: "portainer/portainer", want: true},
+		{image: "portainer/portainer-updater:latest", want: false},
+		{image: "portainer/portainer-ee-custom:2.19.0", want: false},
+		{image: "registry.example.com:5000/portainer/portainer-ee:2.19.0", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.image, func(t *testing.T) {
+			if got := hasImageRepository(tt.image, portainerRepositories); got != tt.want {
+				t.Errorf("hasImageRepository(%s) = %v, want %v", tt.image, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHasImagePrefix(t *testing.T) {
+	prefixes := append(agentImagePrefixes, "registry.example.com/mirror/agent")
+
+	tests := []struct {
+		image string
+		want  bool
+	}{
+		{image: "portainer/agent:2.19.0", want: true},
+		{image: "docker.io/portainerci/agent:develop", want: true},
+		{image: "registry.example.com/mirror/agent:2.19.0", want: true},
+		{image: "registry.example.com/portainer/agent:2.19.0", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.image, func(t *testing.T) {
+			if got := hasImagePrefix(tt.image, prefixes); got != tt.want {
+				t.Errorf("hasImagePrefix(%s) = %v, want %v", tt.image, got, tt.want)
+			}
+		})
+	}
+}
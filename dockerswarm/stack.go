@@ -0,0 +1,85 @@
+package dockerswarm
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+// StackNamespaceLabel is the label set by `docker stack deploy` on the services of a stack
+const StackNamespaceLabel = "com.docker.stack.namespace"
+
+// StackPolicy defines how the updater handles a service deployed as part of a stack
+type StackPolicy string
+
+const (
+	// StackPolicyWarn updates the service and warns that the next stack deploy will revert the update
+	StackPolicyWarn StackPolicy = "warn"
+	// StackPolicyRefuse doesn't update services deployed as part of a stack
+	StackPolicyRefuse StackPolicy = "refuse"
+)
+
+var errStackManaged = errors.New("service is managed by a stack")
+
+// checkStack applies the stack policy to the service, it returns an error when the update should not go on
+func checkStack(service *swarm.Service, policy StackPolicy) error {
+	namespace := service.Spec.Labels[StackNamespaceLabel]
+	if namespace == "" {
+		return nil
+	}
+
+	if policy == StackPolicyRefuse {
+		log.Error().
+			Str("serviceId", service.ID).
+			Str("stack", namespace).
+			Msg("Service is deployed as part of a stack, update its image in the stack file and redeploy the stack instead")
+
+		return errors.WithMessagef(errStackManaged, "stack %s", namespace)
+	}
+
+	log.Warn().
+		Str("serviceId", service.ID).
+		Str("stack", namespace).
+		Msg("Service is deployed as part of a stack, the next docker stack deploy will revert this update unless the stack file is updated")
+
+	return nil
+}
+
+func printStackSnippet(service *swarm.Service, imageName string, env []string) {
+	err := writeStackSnippet(os.Stdout, service, imageName, env)
+	if err != nil {
+		log.Warn().Err(err).Msg("Unable to print stack file snippet")
+	}
+}
+
+// writeStackSnippet writes the part of the stack file to update so the stack matches the updated service,
+// env holds the environment entries the stack file should define
+func writeStackSnippet(w io.Writer, service *swarm.Service, imageName string, env []string) error {
+	namespace := service.Spec.Labels[StackNamespaceLabel]
+	if namespace == "" {
+		return nil
+	}
+
+	serviceName := strings.TrimPrefix(service.Spec.Name, namespace+"_")
+
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "# stack %s: update the %s service in your stack file\n", namespace, serviceName)
+	fmt.Fprintln(&builder, "services:")
+	fmt.Fprintf(&builder, "  %s:\n", serviceName)
+	fmt.Fprintf(&builder, "    image: %s\n", imageName)
+
+	if len(env) > 0 {
+		fmt.Fprintln(&builder, "    environment:")
+		for _, value := range env {
+			fmt.Fprintf(&builder, "      - %s\n", value)
+		}
+	}
+
+	_, err := io.WriteString(w, builder.String())
+	return err
+}
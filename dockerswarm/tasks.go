@@ -0,0 +1,182 @@
+package dockerswarm
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/docker/docker/client"
+	"github.com/pkg/errors"
+	"github.com/portainer/portainer-updater/utils"
+	"github.com/rs/zerolog/log"
+)
+
+// waitForTasks waits until every node running a task of the service runs a task with the new image,
+// this is needed for global services where the update status doesn't tell which nodes failed
+func waitForTasks(ctx context.Context, dockerCli *client.Client, serviceID, imageName string, timeout time.Duration) error {
+	failures := map[string]string{}
+
+	err := utils.WaitUntil(ctx, func() bool {
+		log.Debug().
+			Str("serviceId", serviceID).
+			Msg("Waiting for service tasks to converge")
+
+		tasks, err := listLatestTasks(ctx, dockerCli, serviceID)
+		if err != nil {
+			log.Err(err).
+				Str("serviceId", serviceID).
+				Msg("Unable to list service tasks")
+			return false
+		}
+
+		failures = map[string]string{}
+		converged := true
+		for nodeID, task := range tasks {
+			if imageWithoutDigest(task.Spec.ContainerSpec.Image) != imageWithoutDigest(imageName) {
+				converged = false
+				continue
+			}
+
+			switch task.Status.State {
+			case swarm.TaskStateRunning:
+			case swarm.TaskStateFailed, swarm.TaskStateRejected, swarm.TaskStateOrphaned:
+				failures[nodeID] = taskError(task)
+				converged = false
+			default:
+				converged = false
+			}
+		}
+
+		return converged
+	}, timeout, 5*time.Second)
+
+	if len(failures) > 0 {
+		nodeNames := getNodeNames(ctx, dockerCli)
+		for nodeID, reason := range failures {
+			log.Error().
+				Str("serviceId", serviceID).
+				Str("nodeId", nodeID).
+				Str("node", nodeNames[nodeID]).
+				Str("reason", reason).
+				Msg("Service task failed on node")
+		}
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if len(failures) > 0 {
+		return errors.Errorf("service tasks failed on %d node(s)", len(failures))
+	}
+
+	return nil
+}
+
+// logFailedTasks logs the errors of the most recent failed task on each node
+func logFailedTasks(ctx context.Context, dockerCli *client.Client, serviceID string) {
+	taskFilters := filters.NewArgs()
+	taskFilters.Add("service", serviceID)
+
+	tasks, err := dockerCli.TaskList(ctx, types.TaskListOptions{
+		Filters: taskFilters,
+	})
+	if err != nil {
+		log.Err(err).
+			Str("serviceId", serviceID).
+			Msg("Unable to list service tasks")
+		return
+	}
+
+	failed := map[string]swarm.Task{}
+	for _, task := range tasks {
+		if task.Status.State != swarm.TaskStateFailed && task.Status.State != swarm.TaskStateRejected {
+			continue
+		}
+
+		if current, ok := failed[task.NodeID]; ok && current.Meta.CreatedAt.After(task.Meta.CreatedAt) {
+			continue
+		}
+
+		failed[task.NodeID] = task
+	}
+
+	nodeNames := getNodeNames(ctx, dockerCli)
+	for nodeID, task := range failed {
+		log.Error().
+			Str("serviceId", serviceID).
+			Str("taskId", task.ID).
+			Str("node", nodeNames[nodeID]).
+			Str("state", string(task.Status.State)).
+			Str("reason", taskError(task)).
+			Msg("Service task failed")
+	}
+}
+
+// listLatestTasks returns the most recent task of the service for each node
+func listLatestTasks(ctx context.Context, dockerCli *client.Client, serviceID string) (map[string]swarm.Task, error) {
+	taskFilters := filters.NewArgs()
+	taskFilters.Add("service", serviceID)
+
+	tasks, err := dockerCli.TaskList(ctx, types.TaskListOptions{
+		Filters: taskFilters,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	latest := map[string]swarm.Task{}
+	for _, task := range tasks {
+		if task.NodeID == "" || task.Spec.ContainerSpec == nil {
+			continue
+		}
+
+		if current, ok := latest[task.NodeID]; ok && current.Meta.CreatedAt.After(task.Meta.CreatedAt) {
+			continue
+		}
+
+		latest[task.NodeID] = task
+	}
+
+	// tasks stopped on purpose (e.g. on a drained node) are not expected to converge
+	for nodeID, task := range latest {
+		if task.DesiredState != swarm.TaskStateRunning && (task.Status.State == swarm.TaskStateShutdown || task.Status.State == swarm.TaskStateComplete) {
+			delete(latest, nodeID)
+		}
+	}
+
+	return latest, nil
+}
+
+func getNodeNames(ctx context.Context, dockerCli *client.Client) map[string]string {
+	names := map[string]string{}
+
+	nodes, err := dockerCli.NodeList(ctx, types.NodeListOptions{})
+	if err != nil {
+		log.Warn().Err(err).Msg("Unable to list swarm nodes")
+		return names
+	}
+
+	for _, node := range nodes {
+		names[node.ID] = node.Description.Hostname
+	}
+
+	return names
+}
+
+func taskError(task swarm.Task) string {
+	if task.Status.Err != "" {
+		return task.Status.Err
+	}
+
+	return task.Status.Message
+}
+
+// imageWithoutDigest strips the digest swarm pins to the image of a service
+func imageWithoutDigest(image string) string {
+	name, _, _ := strings.Cut(image, "@")
+	return name
+}
@@ -16,14 +16,29 @@ import (
 	"github.com/rs/zerolog/log"
 )
 
-var errUpdateFailure = errors.New("update failure")
+var (
+	errUpdateFailure    = errors.New("update failure")
+	errUpdateRolledBack = errors.New("service update was rolled back")
+	errUpdatePaused     = errors.New("service update was paused")
+	errUpdateTimeout    = errors.New("service update timed out")
+)
 
-func Update(ctx context.Context, dockerCli *client.Client, imageName string, service *swarm.Service, updateConfig func(*swarm.ContainerSpec)) error {
+func Update(ctx context.Context, dockerCli *client.Client, imageName string, service *swarm.Service, options UpdateOptions, updateConfig func(*swarm.ContainerSpec)) error {
 	log.Info().
 		Str("serviceId", service.ID).
 		Str("image", imageName).
 		Msg("Starting update process")
 
+	err := checkStack(service, options.StackPolicy)
+	if err != nil {
+		// the snippet lets the operator run the refused update through the stack
+		if options.PrintStackSnippet {
+			printStackSnippet(service, imageName, options.StackEnv)
+		}
+
+		return err
+	}
+
 	log.Debug().
 		Str("image", imageName).
 		Str("containerImage", service.Spec.TaskTemplate.ContainerSpec.Image).
@@ -52,10 +67,11 @@ func Update(ctx context.Context, dockerCli *client.Client, imageName string, ser
 	prevVersion := service.Meta.Version
 	service.Meta.Version = swarm.Version{Index: service.Meta.Version.Index + 1}
 
-	service.Spec.UpdateConfig = &swarm.UpdateConfig{
-		FailureAction: swarm.UpdateFailureActionRollback,
-		Order:         swarm.UpdateOrderStopFirst,
-	}
+	originalUpdateConfig := service.Spec.UpdateConfig
+	originalRollbackConfig := service.Spec.RollbackConfig
+
+	service.Spec.UpdateConfig = mergeUpdateConfig(originalUpdateConfig, options)
+	service.Spec.RollbackConfig = mergeRollbackConfig(originalRollbackConfig, options)
 
 	updateResponse, err := dockerCli.ServiceUpdate(ctx, service.ID, prevVersion, service.Spec, types.ServiceUpdateOptions{})
 	if err != nil {
@@ -69,27 +85,37 @@ func Update(ctx context.Context, dockerCli *client.Client, imageName string, ser
 			Msg("Warnings during service update")
 	}
 
-	err = utils.WaitUntil(ctx, func() bool {
-		log.Debug().
+	// the update and the tasks convergence share the timeout
+	deadline := time.Now().Add(options.Timeout)
+
+	err = waitForUpdate(ctx, dockerCli, service.ID, options.Timeout)
+	if err != nil {
+		log.Err(err).
 			Str("serviceId", service.ID).
-			Msg("Waiting for service update to complete")
+			Msg("Service update did not complete")
 
-		service, _, err := dockerCli.ServiceInspectWithRaw(ctx, service.ID, types.ServiceInspectOptions{})
+		logFailedTasks(ctx, dockerCli, service.ID)
+
+		// submitting the service spec again could resume or retrigger the paused, rolled back or pending update
+		logOriginalUpdateConfig(service.ID, originalUpdateConfig, originalRollbackConfig)
+
+		return err
+	}
+
+	restoreUpdateConfig(ctx, dockerCli, service.ID, originalUpdateConfig, originalRollbackConfig)
+
+	if service.Spec.Mode.Global != nil {
+		err = waitForTasks(ctx, dockerCli, service.ID, imageName, time.Until(deadline))
 		if err != nil {
 			log.Err(err).
 				Str("serviceId", service.ID).
-				Msg("Unable to inspect service")
-			return false
+				Msg("Service tasks did not converge on every node")
+			return errUpdateFailure
 		}
+	}
 
-		return service.UpdateStatus != nil && service.UpdateStatus.State == swarm.UpdateStateCompleted
-	}, 1*time.Minute, 5*time.Second)
-
-	if err != nil {
-		log.Err(err).
-			Str("serviceId", service.ID).
-			Msg("Unable to wait for service update to complete")
-		return errUpdateFailure
+	if options.PrintStackSnippet {
+		printStackSnippet(service, imageName, options.StackEnv)
 	}
 
 	log.Info().
@@ -132,3 +158,64 @@ func pullImage(ctx context.Context, dockerCli *client.Client, imageName string)
 	// Maybe through image digest validation instead of checking the output of the docker pull command
 	return strings.Contains(imagePullOutputBuf.String(), "Image is up to date"), nil
 }
+
+// waitForUpdate waits for the swarm update of the service to reach a final state,
+// an update rolled back or paused by swarm is reported with the update status message
+func waitForUpdate(ctx context.Context, dockerCli *client.Client, serviceID string, timeout time.Duration) error {
+	var updateErr error
+	lastMessage := ""
+
+	err := utils.WaitUntil(ctx, func() bool {
+		log.Debug().
+			Str("serviceId", serviceID).
+			Msg("Waiting for service update to complete")
+
+		service, _, err := dockerCli.ServiceInspectWithRaw(ctx, serviceID, types.ServiceInspectOptions{})
+		if err != nil {
+			log.Err(err).
+				Str("serviceId", serviceID).
+				Msg("Unable to inspect service")
+			return false
+		}
+
+		if service.UpdateStatus == nil {
+			return false
+		}
+
+		lastMessage = service.UpdateStatus.Message
+
+		log.Debug().
+			Str("serviceId", serviceID).
+			Str("state", string(service.UpdateStatus.State)).
+			Str("message", service.UpdateStatus.Message).
+			Msg("Service update status")
+
+		switch service.UpdateStatus.State {
+		case swarm.UpdateStateCompleted:
+			return true
+		case swarm.UpdateStateRollbackCompleted:
+			updateErr = errors.WithMessage(errUpdateRolledBack, service.UpdateStatus.Message)
+			return true
+		case swarm.UpdateStatePaused:
+			updateErr = errors.WithMessage(errUpdatePaused, service.UpdateStatus.Message)
+			return true
+		case swarm.UpdateStateRollbackPaused:
+			updateErr = errors.WithMessage(errUpdatePaused, "rollback paused: "+service.UpdateStatus.Message)
+			return true
+		case swarm.UpdateStateUpdating, swarm.UpdateStateRollbackStarted:
+			return false
+		}
+
+		return false
+	}, timeout, 5*time.Second)
+
+	if err != nil {
+		if lastMessage != "" {
+			return errors.WithMessagef(errUpdateTimeout, "last status: %s", lastMessage)
+		}
+
+		return errUpdateTimeout
+	}
+
+	return updateErr
+}
@@ -0,0 +1,186 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/rs/zerolog/log"
+	appV1 "k8s.io/api/apps/v1"
+	coreV1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// logTailLines is the number of container log lines printed when a rollout fails
+const logTailLines = int64(50)
+
+// printRolloutDiagnostics prints the events and the logs of the pods created by the failed rollout,
+// it's best effort and only logs the errors it encounters
+func printRolloutDiagnostics(ctx context.Context, cli kubernetes.Interface, namespace, deploymentName string) {
+	deployment, err := cli.AppsV1().Deployments(namespace).Get(ctx, deploymentName, metaV1.GetOptions{})
+	if err != nil {
+		log.Err(err).
+			Str("deploymentName", deploymentName).
+			Msg("Unable to get deployment")
+		return
+	}
+
+	replicaSet, err := findNewReplicaSet(ctx, cli, deployment)
+	if err != nil {
+		log.Err(err).
+			Str("deploymentName", deploymentName).
+			Msg("Unable to find the replica set of the rollout")
+		return
+	}
+
+	pods, err := listReplicaSetPods(ctx, cli, replicaSet)
+	if err != nil {
+		log.Err(err).
+			Str("replicaSet", replicaSet.Name).
+			Msg("Unable to list pods")
+		return
+	}
+
+	if len(pods) == 0 {
+		log.Error().
+			Str("replicaSet", replicaSet.Name).
+			Msg("No pods were created for the new replica set")
+	}
+
+	for i := range pods {
+		printPodEvents(ctx, cli, &pods[i])
+		printPodLogs(ctx, cli, &pods[i])
+	}
+}
+
+func listReplicaSetPods(ctx context.Context, cli kubernetes.Interface, replicaSet *appV1.ReplicaSet) ([]coreV1.Pod, error) {
+	selector, err := metaV1.LabelSelectorAsSelector(replicaSet.Spec.Selector)
+	if err != nil {
+		return nil, err
+	}
+
+	list, err := cli.CoreV1().Pods(replicaSet.Namespace).List(ctx, metaV1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return nil, err
+	}
+
+	var pods []coreV1.Pod
+	for _, pod := range list.Items {
+		if metaV1.IsControlledBy(&pod, replicaSet) {
+			pods = append(pods, pod)
+		}
+	}
+
+	return pods, nil
+}
+
+func printPodEvents(ctx context.Context, cli kubernetes.Interface, pod *coreV1.Pod) {
+	events, err := cli.CoreV1().Events(pod.Namespace).List(ctx, metaV1.ListOptions{
+		FieldSelector: fmt.Sprintf("involvedObject.kind=Pod,involvedObject.name=%s,involvedObject.uid=%s", pod.Name, pod.UID),
+	})
+	if err != nil {
+		log.Err(err).
+			Str("pod", pod.Name).
+			Msg("Unable to list pod events")
+		return
+	}
+
+	for _, event := range events.Items {
+		if event.Type == coreV1.EventTypeNormal {
+			continue
+		}
+
+		log.Error().
+			Str("pod", pod.Name).
+			Str("reason", event.Reason).
+			Int32("count", event.Count).
+			Str("message", event.Message).
+			Msg("Pod event")
+	}
+
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.State.Waiting != nil {
+			log.Error().
+				Str("pod", pod.Name).
+				Str("container", status.Name).
+				Str("reason", status.State.Waiting.Reason).
+				Str("message", status.State.Waiting.Message).
+				Msg("Container is waiting")
+		}
+
+		if status.LastTerminationState.Terminated != nil {
+			log.Error().
+				Str("pod", pod.Name).
+				Str("container", status.Name).
+				Str("reason", status.LastTerminationState.Terminated.Reason).
+				Int32("exitCode", status.LastTerminationState.Terminated.ExitCode).
+				Int32("restartCount", status.RestartCount).
+				Msg("Container terminated")
+		}
+	}
+}
+
+func printPodLogs(ctx context.Context, cli kubernetes.Interface, pod *coreV1.Pod) {
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.Ready {
+			continue
+		}
+
+		// the current container may not have started yet, the previous one holds the reason of the crash
+		if status.RestartCount > 0 {
+			printContainerLogs(ctx, cli, pod, status.Name, true)
+		}
+
+		if status.State.Running != nil || status.State.Terminated != nil {
+			printContainerLogs(ctx, cli, pod, status.Name, false)
+		}
+	}
+}
+
+func printContainerLogs(ctx context.Context, cli kubernetes.Interface, pod *coreV1.Pod, containerName string, previous bool) {
+	log.Debug().
+		Str("pod", pod.Name).
+		Str("container", containerName).
+		Bool("previous", previous).
+		Msg("Printing container logs to stdout")
+
+	tailLines := logTailLines
+	reader, err := cli.CoreV1().Pods(pod.Namespace).GetLogs(pod.Name, &coreV1.PodLogOptions{
+		Container: containerName,
+		TailLines: &tailLines,
+		Previous:  previous,
+	}).Stream(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("Unable to get container logs")
+		return
+	}
+
+	defer reader.Close()
+
+	_, err = io.Copy(os.Stdout, reader)
+	if err != nil {
+		log.Error().Err(err).Msg("Unable to print container logs")
+	}
+}
+
+// printStatefulSetDiagnostics prints the events and the logs of the statefulset pods that are not ready
+func printStatefulSetDiagnostics(ctx context.Context, cli kubernetes.Interface, statefulSet *appV1.StatefulSet) {
+	pods, err := listStatefulSetPods(ctx, cli, statefulSet)
+	if err != nil {
+		log.Err(err).
+			Str("statefulSetName", statefulSet.Name).
+			Msg("Unable to list pods")
+		return
+	}
+
+	for i := range pods {
+		if isPodReady(&pods[i]) {
+			continue
+		}
+
+		printPodEvents(ctx, cli, &pods[i])
+		printPodLogs(ctx, cli, &pods[i])
+	}
+}
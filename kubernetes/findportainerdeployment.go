@@ -16,7 +16,7 @@ func FindPortainerDeployment(ctx context.Context, cli *kubernetes.Clientset) (*a
 	}
 
 	if len(list.Items) == 0 {
-		return nil, errors.New("no deployments found")
+		return nil, nil
 	}
 
 	if len(list.Items) > 1 {
@@ -0,0 +1,28 @@
+package kubernetes
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	appV1 "k8s.io/api/apps/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+func FindPortainerStatefulSet(ctx context.Context, cli *kubernetes.Clientset) (*appV1.StatefulSet, error) {
+	list, err := cli.AppsV1().StatefulSets("portainer").List(ctx, metaV1.ListOptions{LabelSelector: "app.kubernetes.io/name=portainer"})
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to list statefulsets")
+	}
+
+	if len(list.Items) == 0 {
+		return nil, nil
+	}
+
+	if len(list.Items) > 1 {
+		return nil, errors.New("multiple statefulsets found")
+	}
+
+	return &list.Items[0], nil
+
+}
@@ -0,0 +1,103 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	appV1 "k8s.io/api/apps/v1"
+	authorizationV1 "k8s.io/api/authorization/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+type permission struct {
+	verb        string
+	group       string
+	resource    string
+	subresource string
+}
+
+func (p permission) String() string {
+	resource := p.resource
+	if p.subresource != "" {
+		resource = fmt.Sprintf("%s/%s", resource, p.subresource)
+	}
+
+	if p.group != "" {
+		resource = fmt.Sprintf("%s.%s", resource, p.group)
+	}
+
+	return fmt.Sprintf("%s %s", p.verb, resource)
+}
+
+// podDiagnosticsPermissions are needed to print the pods events and logs when the rollout fails
+var podDiagnosticsPermissions = []permission{
+	{verb: "list", resource: "pods"},
+	{verb: "get", resource: "pods", subresource: "log"},
+	{verb: "list", resource: "events"},
+}
+
+func deploymentPermissions(deployment *appV1.Deployment) []permission {
+	permissions := []permission{
+		{verb: "get", group: "apps", resource: "deployments"},
+		{verb: "watch", group: "apps", resource: "deployments"},
+		{verb: "patch", group: "apps", resource: "deployments"},
+		{verb: "list", group: "apps", resource: "replicasets"},
+	}
+
+	if deployment.Spec.Strategy.Type != appV1.RecreateDeploymentStrategyType {
+		permissions = append(permissions, permission{verb: "get", resource: "persistentvolumeclaims"})
+	}
+
+	return append(permissions, podDiagnosticsPermissions...)
+}
+
+func statefulSetPermissions() []permission {
+	permissions := []permission{
+		{verb: "get", group: "apps", resource: "statefulsets"},
+		{verb: "patch", group: "apps", resource: "statefulsets"},
+		{verb: "delete", resource: "pods"},
+	}
+
+	return append(permissions, podDiagnosticsPermissions...)
+}
+
+// checkPermissions verifies with SelfSubjectAccessReviews that the updater is allowed to perform every operation of the update,
+// so it can abort before making any change
+func checkPermissions(ctx context.Context, cli kubernetes.Interface, namespace string, permissions []permission) error {
+	var missing []string
+	for _, p := range permissions {
+		review, err := cli.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, &authorizationV1.SelfSubjectAccessReview{
+			Spec: authorizationV1.SelfSubjectAccessReviewSpec{
+				ResourceAttributes: &authorizationV1.ResourceAttributes{
+					Namespace:   namespace,
+					Verb:        p.verb,
+					Group:       p.group,
+					Resource:    p.resource,
+					Subresource: p.subresource,
+				},
+			},
+		}, metaV1.CreateOptions{})
+		if err != nil {
+			return errors.WithMessagef(err, "unable to review permission %s", p)
+		}
+
+		if !review.Status.Allowed {
+			missing = append(missing, p.String())
+		}
+	}
+
+	if len(missing) > 0 {
+		log.Error().
+			Str("namespace", namespace).
+			Strs("missingPermissions", missing).
+			Msg("The updater service account is missing permissions")
+
+		return errors.Errorf("missing permissions in namespace %s: %s", namespace, strings.Join(missing, ", "))
+	}
+
+	return nil
+}
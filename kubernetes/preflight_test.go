@@ -0,0 +1,71 @@
+package kubernetes
+
+import (
+	"context"
+	"testing"
+
+	authorizationV1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestCheckPermissions(t *testing.T) {
+	permissions := []permission{
+		{verb: "get", group: "apps", resource: "statefulsets"},
+		{verb: "delete", resource: "pods"},
+		{verb: "get", resource: "pods", subresource: "log"},
+	}
+
+	tests := []struct {
+		name    string
+		denied  map[string]bool
+		wantErr bool
+	}{
+		{
+			name: "allowed",
+		},
+		{
+			name:    "denied",
+			denied:  map[string]bool{"delete pods": true},
+			wantErr: true,
+		},
+		{
+			name:    "denied subresource",
+			denied:  map[string]bool{"get pods/log": true},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var reviewed []string
+
+			cli := fake.NewSimpleClientset()
+			cli.PrependReactor("create", "selfsubjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				review := action.(k8stesting.CreateAction).GetObject().(*authorizationV1.SelfSubjectAccessReview)
+				attributes := review.Spec.ResourceAttributes
+
+				p := permission{verb: attributes.Verb, group: attributes.Group, resource: attributes.Resource, subresource: attributes.Subresource}
+				reviewed = append(reviewed, p.String())
+
+				if attributes.Namespace != "portainer" {
+					t.Errorf("review namespace = %s, want portainer", attributes.Namespace)
+				}
+
+				review.Status.Allowed = !tt.denied[p.String()]
+
+				return true, review, nil
+			})
+
+			err := checkPermissions(context.Background(), cli, "portainer", permissions)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("checkPermissions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if len(reviewed) != len(permissions) {
+				t.Errorf("reviewed %v, want every permission reviewed", reviewed)
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package kubernetes
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	appV1 "k8s.io/api/apps/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// revisionAnnotation is the annotation used by the deployment controller to store the revision of a deployment and its replica sets
+const revisionAnnotation = "deployment.kubernetes.io/revision"
+
+// listReplicaSets returns the replica sets owned by the deployment
+func listReplicaSets(ctx context.Context, cli kubernetes.Interface, deployment *appV1.Deployment) ([]appV1.ReplicaSet, error) {
+	selector, err := metaV1.LabelSelectorAsSelector(deployment.Spec.Selector)
+	if err != nil {
+		return nil, errors.WithMessage(err, "invalid deployment selector")
+	}
+
+	list, err := cli.AppsV1().ReplicaSets(deployment.Namespace).List(ctx, metaV1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to list replica sets")
+	}
+
+	var replicaSets []appV1.ReplicaSet
+	for _, replicaSet := range list.Items {
+		if metaV1.IsControlledBy(&replicaSet, deployment) {
+			replicaSets = append(replicaSets, replicaSet)
+		}
+	}
+
+	return replicaSets, nil
+}
+
+// findNewReplicaSet returns the replica set matching the current revision of the deployment
+func findNewReplicaSet(ctx context.Context, cli kubernetes.Interface, deployment *appV1.Deployment) (*appV1.ReplicaSet, error) {
+	replicaSets, err := listReplicaSets(ctx, cli, deployment)
+	if err != nil {
+		return nil, err
+	}
+
+	revision := deployment.Annotations[revisionAnnotation]
+	for i := range replicaSets {
+		if replicaSets[i].Annotations[revisionAnnotation] == revision {
+			return &replicaSets[i], nil
+		}
+	}
+
+	return nil, errors.Errorf("no replica set found for revision %s", revision)
+}
@@ -0,0 +1,79 @@
+package kubernetes
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	appV1 "k8s.io/api/apps/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	types "k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// rollbackDeployment restores the pod template of the replica set matching the revision of the original deployment,
+// the same way `kubectl rollout undo` does, and waits for the rollback to complete
+func rollbackDeployment(ctx context.Context, cli kubernetes.Interface, originalDeployment *appV1.Deployment, timeout time.Duration) error {
+	deployCli := cli.AppsV1().Deployments(originalDeployment.Namespace)
+
+	deployment, err := deployCli.Get(ctx, originalDeployment.Name, metaV1.GetOptions{})
+	if err != nil {
+		return errors.WithMessage(err, "unable to get deployment")
+	}
+
+	originalRevision := originalDeployment.Annotations[revisionAnnotation]
+	if deployment.Annotations[revisionAnnotation] == originalRevision {
+		log.Info().
+			Str("deploymentName", deployment.Name).
+			Str("revision", originalRevision).
+			Msg("Deployment is still at its original revision, nothing to roll back")
+
+		return nil
+	}
+
+	replicaSets, err := listReplicaSets(ctx, cli, deployment)
+	if err != nil {
+		return err
+	}
+
+	var previous *appV1.ReplicaSet
+	for i := range replicaSets {
+		if replicaSets[i].Annotations[revisionAnnotation] == originalRevision {
+			previous = &replicaSets[i]
+			break
+		}
+	}
+
+	if previous == nil {
+		return errors.Errorf("unable to find replica set for revision %s", originalRevision)
+	}
+
+	template := previous.Spec.Template.DeepCopy()
+	delete(template.Labels, appV1.DefaultDeploymentUniqueLabelKey)
+
+	patchBytes, err := json.Marshal([]jsonPatch{
+		{
+			Op:    "replace",
+			Path:  "/spec/template",
+			Value: template,
+		},
+	})
+	if err != nil {
+		return errors.WithMessage(err, "unable to marshal patch")
+	}
+
+	log.Debug().
+		Str("deploymentName", deployment.Name).
+		Str("revision", originalRevision).
+		Str("replicaSet", previous.Name).
+		Msg("Restoring pod template from replica set")
+
+	newDeployment, err := deployCli.Patch(ctx, deployment.Name, types.JSONPatchType, patchBytes, metaV1.PatchOptions{})
+	if err != nil {
+		return errors.WithMessage(err, "unable to patch deployment")
+	}
+
+	return waitForDeployment(ctx, deployCli, newDeployment.Name, newDeployment.UID, timeout)
+}
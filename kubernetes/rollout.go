@@ -0,0 +1,101 @@
+package kubernetes
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	appV1 "k8s.io/api/apps/v1"
+	coreV1 "k8s.io/api/core/v1"
+)
+
+// progressDeadlineExceededReason is the reason set by the deployment controller on the Progressing condition
+// when the rollout did not progress within spec.progressDeadlineSeconds
+const progressDeadlineExceededReason = "ProgressDeadlineExceeded"
+
+// rolloutStatus reports whether the rollout of a deployment is complete, using the same criteria as `kubectl rollout status`
+func rolloutStatus(deployment *appV1.Deployment) (done bool, message string, err error) {
+	if deployment.Generation > deployment.Status.ObservedGeneration {
+		return false, "waiting for deployment spec update to be observed", nil
+	}
+
+	for _, condition := range deployment.Status.Conditions {
+		if condition.Type == appV1.DeploymentReplicaFailure && condition.Status == coreV1.ConditionTrue {
+			return false, "", errors.Errorf("deployment replica failure: %s", condition.Message)
+		}
+
+		if condition.Type == appV1.DeploymentProgressing && condition.Reason == progressDeadlineExceededReason {
+			return false, "", errors.Errorf("deployment exceeded its progress deadline: %s", condition.Message)
+		}
+	}
+
+	replicas := int32(1)
+	if deployment.Spec.Replicas != nil {
+		replicas = *deployment.Spec.Replicas
+	}
+
+	status := deployment.Status
+
+	if status.UpdatedReplicas < replicas {
+		return false, fmt.Sprintf("%d out of %d new replicas have been updated", status.UpdatedReplicas, replicas), nil
+	}
+
+	if status.Replicas > status.UpdatedReplicas {
+		return false, fmt.Sprintf("%d old replicas are pending termination", status.Replicas-status.UpdatedReplicas), nil
+	}
+
+	if status.AvailableReplicas < status.UpdatedReplicas {
+		return false, fmt.Sprintf("%d of %d updated replicas are available", status.AvailableReplicas, status.UpdatedReplicas), nil
+	}
+
+	return true, "deployment successfully rolled out", nil
+}
+
+// statefulSetRolloutStatus reports whether the rollout of a statefulset is complete, using the same criteria as `kubectl rollout status`,
+// statefulsets using the OnDelete strategy are complete once every pod runs the update revision
+func statefulSetRolloutStatus(statefulSet *appV1.StatefulSet) (done bool, message string) {
+	if statefulSet.Status.ObservedGeneration == 0 || statefulSet.Generation > statefulSet.Status.ObservedGeneration {
+		return false, "waiting for statefulset spec update to be observed"
+	}
+
+	replicas := int32(1)
+	if statefulSet.Spec.Replicas != nil {
+		replicas = *statefulSet.Spec.Replicas
+	}
+
+	status := statefulSet.Status
+
+	if status.ReadyReplicas < replicas {
+		return false, fmt.Sprintf("%d of %d pods are ready", status.ReadyReplicas, replicas)
+	}
+
+	if statefulSet.Spec.UpdateStrategy.Type == appV1.OnDeleteStatefulSetStrategyType {
+		if status.UpdatedReplicas < replicas {
+			return false, fmt.Sprintf("%d of %d pods have been updated", status.UpdatedReplicas, replicas)
+		}
+
+		return true, "statefulset successfully rolled out"
+	}
+
+	if partition := statefulSetPartition(statefulSet); partition > 0 {
+		if status.UpdatedReplicas < replicas-partition {
+			return false, fmt.Sprintf("%d of %d pods above the partition have been updated", status.UpdatedReplicas, replicas-partition)
+		}
+
+		return true, "partitioned roll out complete"
+	}
+
+	if status.UpdateRevision != status.CurrentRevision {
+		return false, fmt.Sprintf("waiting for pods to be updated to revision %s", status.UpdateRevision)
+	}
+
+	return true, "statefulset successfully rolled out"
+}
+
+func statefulSetPartition(statefulSet *appV1.StatefulSet) int32 {
+	rollingUpdate := statefulSet.Spec.UpdateStrategy.RollingUpdate
+	if rollingUpdate == nil || rollingUpdate.Partition == nil {
+		return 0
+	}
+
+	return *rollingUpdate.Partition
+}
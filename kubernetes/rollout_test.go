@@ -0,0 +1,146 @@
+package kubernetes
+
+import (
+	"testing"
+
+	appV1 "k8s.io/api/apps/v1"
+	coreV1 "k8s.io/api/core/v1"
+)
+
+func TestRolloutStatus(t *testing.T) {
+	replicas := int32(1)
+
+	tests := []struct {
+		name       string
+		generation int64
+		status     appV1.DeploymentStatus
+		wantDone   bool
+		wantErr    bool
+	}{
+		{
+			name:       "spec update not observed yet",
+			generation: 2,
+			status:     appV1.DeploymentStatus{ObservedGeneration: 1, Replicas: 1, UpdatedReplicas: 1, ReadyReplicas: 1, AvailableReplicas: 1},
+			wantDone:   false,
+		},
+		{
+			name:       "old replica still serving",
+			generation: 2,
+			status:     appV1.DeploymentStatus{ObservedGeneration: 2, Replicas: 2, UpdatedReplicas: 1, ReadyReplicas: 1, AvailableReplicas: 1},
+			wantDone:   false,
+		},
+		{
+			name:       "new replica not available",
+			generation: 2,
+			status:     appV1.DeploymentStatus{ObservedGeneration: 2, Replicas: 1, UpdatedReplicas: 1},
+			wantDone:   false,
+		},
+		{
+			name:       "progress deadline exceeded",
+			generation: 2,
+			status: appV1.DeploymentStatus{ObservedGeneration: 2, Replicas: 2, UpdatedReplicas: 1, Conditions: []appV1.DeploymentCondition{
+				{Type: appV1.DeploymentProgressing, Status: coreV1.ConditionFalse, Reason: progressDeadlineExceededReason},
+			}},
+			wantErr: true,
+		},
+		{
+			name:       "replica failure",
+			generation: 2,
+			status: appV1.DeploymentStatus{ObservedGeneration: 2, Conditions: []appV1.DeploymentCondition{
+				{Type: appV1.DeploymentReplicaFailure, Status: coreV1.ConditionTrue},
+			}},
+			wantErr: true,
+		},
+		{
+			name:       "rollout complete",
+			generation: 2,
+			status:     appV1.DeploymentStatus{ObservedGeneration: 2, Replicas: 1, UpdatedReplicas: 1, ReadyReplicas: 1, AvailableReplicas: 1},
+			wantDone:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deployment := &appV1.Deployment{
+				Spec:   appV1.DeploymentSpec{Replicas: &replicas},
+				Status: tt.status,
+			}
+			deployment.Generation = tt.generation
+
+			done, _, err := rolloutStatus(deployment)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("rolloutStatus() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if done != tt.wantDone {
+				t.Errorf("rolloutStatus() = %v, want %v", done, tt.wantDone)
+			}
+		})
+	}
+}
+
+func TestStatefulSetRolloutStatus(t *testing.T) {
+	replicas := int32(2)
+	partition := int32(1)
+
+	tests := []struct {
+		name     string
+		strategy appV1.StatefulSetUpdateStrategy
+		status   appV1.StatefulSetStatus
+		wantDone bool
+	}{
+		{
+			name:     "spec update not observed yet",
+			strategy: appV1.StatefulSetUpdateStrategy{Type: appV1.RollingUpdateStatefulSetStrategyType},
+			status:   appV1.StatefulSetStatus{ObservedGeneration: 1, ReadyReplicas: 2, UpdatedReplicas: 2},
+			wantDone: false,
+		},
+		{
+			name:     "rolling update in progress",
+			strategy: appV1.StatefulSetUpdateStrategy{Type: appV1.RollingUpdateStatefulSetStrategyType},
+			status:   appV1.StatefulSetStatus{ObservedGeneration: 2, ReadyReplicas: 2, UpdatedReplicas: 1, CurrentRevision: "a", UpdateRevision: "b"},
+			wantDone: false,
+		},
+		{
+			name:     "rolling update complete",
+			strategy: appV1.StatefulSetUpdateStrategy{Type: appV1.RollingUpdateStatefulSetStrategyType},
+			status:   appV1.StatefulSetStatus{ObservedGeneration: 2, ReadyReplicas: 2, UpdatedReplicas: 2, CurrentRevision: "b", UpdateRevision: "b"},
+			wantDone: true,
+		},
+		{
+			name: "partitioned roll out complete",
+			strategy: appV1.StatefulSetUpdateStrategy{
+				Type:          appV1.RollingUpdateStatefulSetStrategyType,
+				RollingUpdate: &appV1.RollingUpdateStatefulSetStrategy{Partition: &partition},
+			},
+			status:   appV1.StatefulSetStatus{ObservedGeneration: 2, ReadyReplicas: 2, UpdatedReplicas: 1, CurrentRevision: "a", UpdateRevision: "b"},
+			wantDone: true,
+		},
+		{
+			name:     "on delete with outdated pods",
+			strategy: appV1.StatefulSetUpdateStrategy{Type: appV1.OnDeleteStatefulSetStrategyType},
+			status:   appV1.StatefulSetStatus{ObservedGeneration: 2, ReadyReplicas: 2, UpdatedReplicas: 1, CurrentRevision: "a", UpdateRevision: "b"},
+			wantDone: false,
+		},
+		{
+			name:     "on delete with every pod updated",
+			strategy: appV1.StatefulSetUpdateStrategy{Type: appV1.OnDeleteStatefulSetStrategyType},
+			status:   appV1.StatefulSetStatus{ObservedGeneration: 2, ReadyReplicas: 2, UpdatedReplicas: 2, CurrentRevision: "a", UpdateRevision: "b"},
+			wantDone: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			statefulSet := &appV1.StatefulSet{
+				Spec:   appV1.StatefulSetSpec{Replicas: &replicas, UpdateStrategy: tt.strategy},
+				Status: tt.status,
+			}
+			statefulSet.Generation = 2
+
+			if done, _ := statefulSetRolloutStatus(statefulSet); done != tt.wantDone {
+				t.Errorf("statefulSetRolloutStatus() = %v, want %v", done, tt.wantDone)
+			}
+		})
+	}
+}
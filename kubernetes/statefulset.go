@@ -0,0 +1,228 @@
+package kubernetes
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/portainer/portainer-updater/utils"
+	"github.com/rs/zerolog/log"
+	appV1 "k8s.io/api/apps/v1"
+	coreV1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	types "k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+var errPartitionNotApplied = errors.New("update not applied, the statefulset partition excludes every pod")
+
+func UpdateStatefulSet(ctx context.Context, cli *kubernetes.Clientset, imageName string, statefulSet *appV1.StatefulSet, licenseKey string, timeout time.Duration) error {
+	log.Info().
+		Str("statefulSetName", statefulSet.Name).
+		Str("strategy", string(statefulSet.Spec.UpdateStrategy.Type)).
+		Str("image", imageName).
+		Msg("Starting update process")
+
+	err := checkPermissions(ctx, cli, statefulSet.Namespace, statefulSetPermissions())
+	if err != nil {
+		return errors.WithMessage(err, "pre-flight check failed")
+	}
+
+	replicas := int32(1)
+	if statefulSet.Spec.Replicas != nil {
+		replicas = *statefulSet.Spec.Replicas
+	}
+
+	// the statefulset isn't patched as no pod would run the new image
+	if partition := statefulSetPartition(statefulSet); partition >= replicas {
+		log.Error().
+			Str("statefulSetName", statefulSet.Name).
+			Int32("partition", partition).
+			Int32("replicas", replicas).
+			Msg("The statefulset partition is not lower than its replicas, no pod would be updated")
+
+		return errors.WithMessagef(errPartitionNotApplied, "partition %d, replicas %d", partition, replicas)
+	}
+
+	patch := []jsonPatch{
+		{
+			Op:    "replace",
+			Path:  "/spec/template/spec/containers/0/image",
+			Value: imageName,
+		},
+	}
+
+	if licenseKey != "" {
+		patch = append(patch, createEnvVarPatch(licenseKey, statefulSet.Spec.Template.Spec.Containers[0].Env))
+	}
+
+	deleteOutdatedPods := statefulSet.Spec.UpdateStrategy.Type == appV1.OnDeleteStatefulSetStrategyType
+
+	err = patchStatefulSet(ctx, cli, statefulSet.Namespace, statefulSet.Name, patch, deleteOutdatedPods, timeout)
+	if err != nil {
+		log.Err(err).
+			Str("statefulSetName", statefulSet.Name).
+			Msg("Unable to update statefulset")
+
+		printStatefulSetDiagnostics(ctx, cli, statefulSet)
+
+		log.Info().
+			Str("statefulSetName", statefulSet.Name).
+			Msg("Rolling back statefulset")
+
+		// a pod that never becomes ready blocks the rolling update, so the rollback replaces the outdated pods itself
+		err := patchStatefulSet(ctx, cli, statefulSet.Namespace, statefulSet.Name, []jsonPatch{
+			{
+				Op:    "replace",
+				Path:  "/spec/template",
+				Value: statefulSet.Spec.Template,
+			},
+		}, true, timeout)
+		if err != nil {
+			log.Err(err).
+				Str("statefulSetName", statefulSet.Name).
+				Msg("Unable to rollback statefulset")
+		}
+
+		return errUpdateFailure
+	}
+
+	log.Info().
+		Str("statefulSetName", statefulSet.Name).
+		Str("image", imageName).
+		Msg("Update process completed")
+
+	return nil
+}
+
+func patchStatefulSet(ctx context.Context, cli kubernetes.Interface, namespace, name string, patch []jsonPatch, deleteOutdatedPods bool, timeout time.Duration) error {
+	patchBytes, err := json.Marshal(patch)
+	if err != nil {
+		return errors.WithMessage(err, "unable to marshal patch")
+	}
+
+	_, err = cli.AppsV1().StatefulSets(namespace).
+		Patch(ctx, name, types.JSONPatchType, patchBytes, metaV1.PatchOptions{})
+	if err != nil {
+		return errors.WithMessage(err, "unable to patch statefulset")
+	}
+
+	log.Debug().
+		Str("statefulSetName", name).
+		Msg("Waiting for statefulset to complete")
+
+	return waitForStatefulSet(ctx, cli, namespace, name, deleteOutdatedPods, timeout)
+}
+
+func waitForStatefulSet(ctx context.Context, cli kubernetes.Interface, namespace, name string, deleteOutdatedPods bool, timeout time.Duration) error {
+	return utils.WaitUntil(ctx, func() bool {
+		statefulSet, err := cli.AppsV1().StatefulSets(namespace).Get(ctx, name, metaV1.GetOptions{})
+		if err != nil {
+			log.Err(err).
+				Str("statefulSetName", name).
+				Msg("Unable to get statefulset")
+			return false
+		}
+
+		done, message := statefulSetRolloutStatus(statefulSet)
+
+		log.Debug().
+			Str("statefulSetName", name).
+			Str("status", message).
+			Msg("Statefulset rollout status")
+
+		if done {
+			return true
+		}
+
+		if deleteOutdatedPods && statefulSet.Generation <= statefulSet.Status.ObservedGeneration {
+			err := deleteOutdatedPod(ctx, cli, statefulSet)
+			if err != nil {
+				log.Err(err).
+					Str("statefulSetName", name).
+					Msg("Unable to delete outdated pod")
+			}
+		}
+
+		return false
+	}, timeout, 5*time.Second)
+}
+
+// deleteOutdatedPod deletes the pod with the highest ordinal that doesn't run the update revision,
+// one pod at a time like the RollingUpdate strategy does
+func deleteOutdatedPod(ctx context.Context, cli kubernetes.Interface, statefulSet *appV1.StatefulSet) error {
+	pods, err := listStatefulSetPods(ctx, cli, statefulSet)
+	if err != nil {
+		return err
+	}
+
+	var outdated []coreV1.Pod
+	for _, pod := range pods {
+		if pod.DeletionTimestamp != nil {
+			return nil
+		}
+
+		if pod.Labels[appV1.StatefulSetRevisionLabel] != statefulSet.Status.UpdateRevision {
+			outdated = append(outdated, pod)
+			continue
+		}
+
+		if !isPodReady(&pod) {
+			return nil
+		}
+	}
+
+	if len(outdated) == 0 {
+		return nil
+	}
+
+	// pod names end with their ordinal, sorting them by name length first keeps the numeric order
+	sort.Slice(outdated, func(i, j int) bool {
+		if len(outdated[i].Name) != len(outdated[j].Name) {
+			return len(outdated[i].Name) > len(outdated[j].Name)
+		}
+
+		return outdated[i].Name > outdated[j].Name
+	})
+
+	log.Info().
+		Str("statefulSetName", statefulSet.Name).
+		Str("pod", outdated[0].Name).
+		Str("revision", statefulSet.Status.UpdateRevision).
+		Msg("Deleting outdated pod")
+
+	return cli.CoreV1().Pods(statefulSet.Namespace).Delete(ctx, outdated[0].Name, metaV1.DeleteOptions{})
+}
+
+func listStatefulSetPods(ctx context.Context, cli kubernetes.Interface, statefulSet *appV1.StatefulSet) ([]coreV1.Pod, error) {
+	selector, err := metaV1.LabelSelectorAsSelector(statefulSet.Spec.Selector)
+	if err != nil {
+		return nil, errors.WithMessage(err, "invalid statefulset selector")
+	}
+
+	list, err := cli.CoreV1().Pods(statefulSet.Namespace).List(ctx, metaV1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to list pods")
+	}
+
+	var pods []coreV1.Pod
+	for _, pod := range list.Items {
+		if metaV1.IsControlledBy(&pod, statefulSet) {
+			pods = append(pods, pod)
+		}
+	}
+
+	return pods, nil
+}
+
+func isPodReady(pod *coreV1.Pod) bool {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == coreV1.PodReady {
+			return condition.Status == coreV1.ConditionTrue
+		}
+	}
+
+	return false
+}
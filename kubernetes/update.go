@@ -12,6 +12,7 @@ import (
 	coreV1 "k8s.io/api/core/v1"
 	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	types "k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/kubernetes"
 	v1 "k8s.io/client-go/kubernetes/typed/apps/v1"
 )
@@ -24,17 +25,28 @@ type (
 	}
 )
 
-const fiveMinutes = int64(300)
+// RWOStrategy defines how the updater handles a RollingUpdate deployment mounting a ReadWriteOnce volume
+type RWOStrategy string
+
+const (
+	// RWOStrategyRecreate switches the deployment to the Recreate strategy for the duration of the update
+	RWOStrategyRecreate RWOStrategy = "recreate"
+	// RWOStrategyWarn keeps the deployment strategy and only logs a warning
+	RWOStrategyWarn RWOStrategy = "warn"
+)
 
 var errUpdateFailure = errors.New("update failure")
 
-func Update(ctx context.Context, cli *kubernetes.Clientset, imageName string, deployment *appV1.Deployment, licenseKey string) error {
+func Update(ctx context.Context, cli *kubernetes.Clientset, imageName string, deployment *appV1.Deployment, licenseKey string, timeout time.Duration, rwoStrategy RWOStrategy) error {
 	log.Info().
 		Str("deploymentName", deployment.Name).
 		Str("image", imageName).
 		Msg("Starting update process")
 
-	originalImage := deployment.Spec.Template.Spec.Containers[0].Image
+	err := checkPermissions(ctx, cli, deployment.Namespace, deploymentPermissions(deployment))
+	if err != nil {
+		return errors.WithMessage(err, "pre-flight check failed")
+	}
 
 	deployCli := cli.AppsV1().
 		Deployments(deployment.Namespace)
@@ -44,17 +56,47 @@ func Update(ctx context.Context, cli *kubernetes.Clientset, imageName string, de
 		patch = append(patch, createEnvVarPatch(licenseKey, deployment.Spec.Template.Spec.Containers[0].Env))
 	}
 
-	err := updateDeployment(ctx, deployCli, deployment.Name, imageName, patch)
+	if deployment.Spec.Strategy.Type != appV1.RecreateDeploymentStrategyType {
+		rwo, err := hasReadWriteOnceVolume(ctx, cli, deployment.Namespace, &deployment.Spec.Template.Spec)
+		if err != nil {
+			log.Warn().
+				Err(err).
+				Str("deploymentName", deployment.Name).
+				Msg("Unable to check the deployment volumes access modes")
+		}
+
+		if rwo && rwoStrategy == RWOStrategyRecreate {
+			log.Info().
+				Str("deploymentName", deployment.Name).
+				Msg("Deployment uses a ReadWriteOnce volume, switching to the Recreate strategy for the update")
+
+			patch = append(patch, jsonPatch{
+				Op:    "replace",
+				Path:  "/spec/strategy",
+				Value: appV1.DeploymentStrategy{Type: appV1.RecreateDeploymentStrategyType},
+			})
+
+			defer restoreStrategy(ctx, deployCli, deployment.Name, deployment.Spec.Strategy)
+		} else if rwo {
+			log.Warn().
+				Str("deploymentName", deployment.Name).
+				Msg("Deployment uses a ReadWriteOnce volume with a rolling update, the new pod may not start until the old one releases the volume")
+		}
+	}
+
+	err = updateDeployment(ctx, deployCli, deployment.Name, imageName, patch, timeout)
 	if err != nil {
 		log.Err(err).
 			Str("deploymentName", deployment.Name).
 			Msg("Unable to update deployment")
 
+		printRolloutDiagnostics(ctx, cli, deployment.Namespace, deployment.Name)
+
 		log.Info().
 			Str("deploymentName", deployment.Name).
 			Msg("Rolling back deployment")
 
-		err := updateDeployment(ctx, deployCli, deployment.Name, originalImage, nil)
+		err := rollbackDeployment(ctx, cli, deployment, timeout)
 		if err != nil {
 			log.Err(err).
 				Str("deploymentName", deployment.Name).
@@ -72,6 +114,33 @@ func Update(ctx context.Context, cli *kubernetes.Clientset, imageName string, de
 	return nil
 }
 
+func restoreStrategy(ctx context.Context, deployCli v1.DeploymentInterface, deploymentName string, strategy appV1.DeploymentStrategy) {
+	log.Debug().
+		Str("deploymentName", deploymentName).
+		Str("strategy", string(strategy.Type)).
+		Msg("Restoring deployment strategy")
+
+	patchBytes, err := json.Marshal([]jsonPatch{
+		{
+			Op:    "replace",
+			Path:  "/spec/strategy",
+			Value: strategy,
+		},
+	})
+	if err != nil {
+		log.Err(err).Msg("Unable to marshal patch")
+		return
+	}
+
+	_, err = deployCli.Patch(ctx, deploymentName, types.JSONPatchType, patchBytes, metaV1.PatchOptions{})
+	if err != nil {
+		log.Err(err).
+			Str("deploymentName", deploymentName).
+			Str("strategy", string(strategy.Type)).
+			Msg("Unable to restore deployment strategy, please restore it manually")
+	}
+}
+
 func createEnvVarPatch(licenseKey string, envVars []coreV1.EnvVar) jsonPatch {
 	licenseKeyEnvVar := coreV1.EnvVar{
 		Name:  "PORTAINER_LICENSE_KEY",
@@ -118,7 +187,7 @@ func Index[E any](slice []E, predicate func(E) bool) (int, bool) {
 	return -1, false
 }
 
-func updateDeployment(ctx context.Context, deployCli v1.DeploymentInterface, deploymentName, imageName string, morePatch []jsonPatch) error {
+func updateDeployment(ctx context.Context, deployCli v1.DeploymentInterface, deploymentName, imageName string, morePatch []jsonPatch, timeout time.Duration) error {
 	patch := append([]jsonPatch{
 		{
 			Op:    "replace",
@@ -142,45 +211,58 @@ func updateDeployment(ctx context.Context, deployCli v1.DeploymentInterface, dep
 		Str("deploymentName", deploymentName).
 		Msg("Waiting for deployment to complete")
 
-	return waitForDeployment(ctx, deployCli, newDeployment.Name, newDeployment.UID)
+	return waitForDeployment(ctx, deployCli, newDeployment.Name, newDeployment.UID, timeout)
 }
 
-func waitForDeployment(ctx context.Context, deployCli v1.DeploymentInterface, deploymentName string, uid types.UID) error {
-	// for some reason when we start, we have both updatedReplicas and readyReplicas set to 1
-	// we will wait 5 seconds before starting to watch
-	time.Sleep(5 * time.Second)
+func waitForDeployment(ctx context.Context, deployCli v1.DeploymentInterface, deploymentName string, uid types.UID, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	// the API server may close the watch before the timeout, in that case we start a new one
+	for ctx.Err() == nil {
+		timeoutSeconds := int64(timeout.Seconds())
+		watcher, err := deployCli.Watch(ctx, metaV1.ListOptions{
+			FieldSelector:  fmt.Sprintf("metadata.name=%s", deploymentName),
+			TimeoutSeconds: &timeoutSeconds,
+		})
+		if err != nil {
+			log.Err(err).
+				Str("deploymentName", deploymentName).
+				Str("deploymentUID", string(uid)).
+				Msg("Unable to watch deployments")
 
-	timeoutSeconds := fiveMinutes
-	watcher, err := deployCli.Watch(ctx, metaV1.ListOptions{
-		FieldSelector:  fmt.Sprintf("metadata.name=%s", deploymentName),
-		TimeoutSeconds: &timeoutSeconds,
-	})
-	if err != nil {
-		log.Err(err).
-			Str("deploymentName", deploymentName).
-			Str("deploymentUID", string(uid)).
-			Msg("Unable to watch deployments")
+			return errors.WithMessage(err, "unable to watch deployments")
+		}
+
+		done, err := watchRollout(watcher.ResultChan(), deploymentName, uid)
+		watcher.Stop()
+		if err != nil {
+			log.Error().
+				Err(err).
+				Str("deploymentName", deploymentName).
+				Msg("Deployment rollout failed")
 
-		return errors.WithMessage(err, "unable to watch deployments")
+			return err
+		}
+
+		if done {
+			return nil
+		}
 	}
 
-	for event := range watcher.ResultChan() {
+	return errors.New("timeout")
+}
+
+func watchRollout(events <-chan watch.Event, deploymentName string, uid types.UID) (bool, error) {
+	for event := range events {
 		deployment, ok := event.Object.(*appV1.Deployment)
 		if !ok || deployment.UID != uid {
 			continue
 		}
 
-		for _, condition := range deployment.Status.Conditions {
-			if condition.Type == appV1.DeploymentReplicaFailure && condition.Status == coreV1.ConditionTrue {
-				log.Error().
-					Str("deploymentName", deploymentName).
-					Str("reason", condition.Message).
-					Msg("Deployment replica failure")
-				return errors.New("deployment replica failure")
-			}
-		}
-
 		log.Debug().
+			Int64("Generation", deployment.Generation).
+			Int64("ObservedGeneration", deployment.Status.ObservedGeneration).
 			Int32("ReadyReplicas", deployment.Status.ReadyReplicas).
 			Int32("AvailableReplicas", deployment.Status.AvailableReplicas).
 			Int32("Replicas", deployment.Status.Replicas).
@@ -188,10 +270,20 @@ func waitForDeployment(ctx context.Context, deployCli v1.DeploymentInterface, de
 			Int32("UpdatedReplicas", deployment.Status.UpdatedReplicas).
 			Msg("checking replicas condition")
 
-		if deployment.Status.UpdatedReplicas > 0 && deployment.Status.ReadyReplicas > 0 {
-			return nil
+		done, message, err := rolloutStatus(deployment)
+		if err != nil {
+			return false, err
+		}
+
+		log.Debug().
+			Str("deploymentName", deploymentName).
+			Str("status", message).
+			Msg("Deployment rollout status")
+
+		if done {
+			return true, nil
 		}
 	}
 
-	return errors.New("timeout")
+	return false, nil
 }
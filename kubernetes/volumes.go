@@ -0,0 +1,33 @@
+package kubernetes
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	coreV1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// hasReadWriteOnceVolume checks whether the pod mounts a persistent volume claim that can only be attached to a single node,
+// in which case a rolling update can't start the new pod before the old one released the volume
+func hasReadWriteOnceVolume(ctx context.Context, cli kubernetes.Interface, namespace string, podSpec *coreV1.PodSpec) (bool, error) {
+	for _, volume := range podSpec.Volumes {
+		if volume.PersistentVolumeClaim == nil {
+			continue
+		}
+
+		claim, err := cli.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, volume.PersistentVolumeClaim.ClaimName, metaV1.GetOptions{})
+		if err != nil {
+			return false, errors.WithMessagef(err, "unable to get persistent volume claim %s", volume.PersistentVolumeClaim.ClaimName)
+		}
+
+		for _, mode := range claim.Spec.AccessModes {
+			if mode == coreV1.ReadWriteOnce || mode == coreV1.ReadWriteOncePod {
+				return true, nil
+			}
+		}
+	}
+
+	return false, nil
+}
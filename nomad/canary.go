@@ -0,0 +1,206 @@
+package nomad
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/nomad/api"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+var errCanaryFailed = errors.New("canary failed")
+
+// canaryUpdateIDPollInterval is the delay between two reads of the update ID of the canary agents
+const canaryUpdateIDPollInterval = 5 * time.Second
+
+// updateIDReader returns the update ID the task of the allocation runs with
+type updateIDReader func(ctx context.Context, allocationID, taskName string) (string, error)
+
+// runCanaryDeployment waits for the canaries of the deployment to be healthy and for their agents to run with the new update ID,
+// then promotes them. Unhealthy canaries or canaries reporting another update ID fail the deployment so Nomad reverts the job.
+func runCanaryDeployment(ctx context.Context, nomadCli *api.Client, job *api.Job, deployment *api.Deployment, tasks []*api.Task, scheduleId string) error {
+	log.Info().
+		Str("deployment", deployment.ID).
+		Str("job", deployment.JobID).
+		Msg("Waiting for canary allocations to be healthy")
+
+	current, err := waitForCanaries(ctx, nomadCli, deployment)
+	if err == nil && scheduleId != "" && current.Status != deploymentStatusSuccessful {
+		log.Info().
+			Str("deployment", deployment.ID).
+			Str("schedule-id", scheduleId).
+			Msg("Waiting for canary agents to report the update ID")
+
+		err = waitForCanariesUpdateID(ctx, current, groupTaskNames(job, tasks), scheduleId, execUpdateIDReader(nomadCli, deployment.Namespace))
+	}
+
+	if err != nil {
+		if current != nil {
+			printDeploymentFailures(ctx, nomadCli, current, tasks)
+		}
+
+		failDeployment(nomadCli, deployment)
+
+		return err
+	}
+
+	log.Info().
+		Str("deployment", deployment.ID).
+		Msg("Canary allocations are healthy, promoting the deployment")
+
+	_, _, err = nomadCli.Deployments().PromoteAll(deployment.ID, (&api.WriteOptions{Namespace: deployment.Namespace}).WithContext(ctx))
+	if err != nil {
+		return errors.WithMessage(err, "failed to promote deployment")
+	}
+
+	return waitForDeployment(ctx, nomadCli, deployment, tasks)
+}
+
+func waitForCanaries(ctx context.Context, nomadCli *api.Client, deployment *api.Deployment) (*api.Deployment, error) {
+	var current *api.Deployment
+
+	index := uint64(0)
+	for {
+		next, meta, err := nomadCli.Deployments().Info(deployment.ID, queryOptions(ctx, deployment.Namespace, index))
+		if err != nil {
+			if ctx.Err() != nil {
+				return current, errors.WithMessage(errCanaryFailed, "timeout")
+			}
+
+			return current, errors.WithMessage(err, "failed to get deployment")
+		}
+
+		current = next
+		index = meta.LastIndex
+
+		switch current.Status {
+		case deploymentStatusFailed, deploymentStatusCancelled:
+			return current, errors.WithMessagef(errCanaryFailed, "%s: %s", current.Status, current.StatusDescription)
+		case deploymentStatusSuccessful:
+			return current, nil
+		}
+
+		healthy := true
+		for group, state := range current.TaskGroups {
+			log.Debug().
+				Str("deployment", current.ID).
+				Str("group", group).
+				Int("desiredCanaries", state.DesiredCanaries).
+				Int("placedCanaries", len(state.PlacedCanaries)).
+				Int("healthy", state.HealthyAllocs).
+				Int("unhealthy", state.UnhealthyAllocs).
+				Msg("Canary progress")
+
+			if state.UnhealthyAllocs > 0 {
+				return current, errors.WithMessagef(errCanaryFailed, "unhealthy canary in group %s", group)
+			}
+
+			if len(state.PlacedCanaries) < state.DesiredCanaries || state.HealthyAllocs < state.DesiredCanaries {
+				healthy = false
+			}
+		}
+
+		if healthy {
+			return current, nil
+		}
+	}
+}
+
+// waitForCanariesUpdateID polls the agent tasks of the canary allocations until they all run with the update ID,
+// a canary running with another update ID fails right away
+func waitForCanariesUpdateID(ctx context.Context, deployment *api.Deployment, groupTasks map[string][]string, scheduleId string, readUpdateID updateIDReader) error {
+	for {
+		verified, total := 0, 0
+		for group, state := range deployment.TaskGroups {
+			for _, allocationID := range state.PlacedCanaries {
+				for _, taskName := range groupTasks[group] {
+					total++
+
+					updateID, err := readUpdateID(ctx, allocationID, taskName)
+					if err != nil {
+						log.Debug().
+							Err(err).
+							Str("allocation", allocationID).
+							Str("task", taskName).
+							Msg("Unable to read the canary update ID")
+
+						continue
+					}
+
+					switch updateID {
+					case scheduleId:
+						verified++
+					case "":
+						// the task env isn't readable yet
+					default:
+						return errors.WithMessagef(errCanaryFailed, "canary allocation %s task %s runs update ID %s instead of %s", allocationID, taskName, updateID, scheduleId)
+					}
+				}
+			}
+		}
+
+		if verified == total {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return errors.WithMessagef(errCanaryFailed, "timeout waiting for the canary agents to report update ID %s, %d of %d verified", scheduleId, verified, total)
+		case <-time.After(canaryUpdateIDPollInterval):
+		}
+	}
+}
+
+// execUpdateIDReader reads the update ID from the env of the running canary task
+func execUpdateIDReader(nomadCli *api.Client, namespace string) updateIDReader {
+	return func(ctx context.Context, allocationID, taskName string) (string, error) {
+		allocation, _, err := nomadCli.Allocations().Info(allocationID, (&api.QueryOptions{Namespace: namespace}).WithContext(ctx))
+		if err != nil {
+			return "", errors.WithMessage(err, "failed to get canary allocation")
+		}
+
+		var stdout, stderr bytes.Buffer
+		exitCode, err := nomadCli.Allocations().Exec(ctx, allocation, taskName, false, []string{"printenv", EnvKeyUpdateID}, strings.NewReader(""), &stdout, &stderr, nil, &api.QueryOptions{Namespace: namespace})
+		if err != nil {
+			return "", errors.WithMessage(err, "failed to exec in canary task")
+		}
+
+		if exitCode != 0 {
+			return "", errors.Errorf("printenv exited with code %d: %s", exitCode, strings.TrimSpace(stderr.String()))
+		}
+
+		return strings.TrimSpace(stdout.String()), nil
+	}
+}
+
+// groupTaskNames returns the names of the agent tasks by task group
+func groupTaskNames(job *api.Job, tasks []*api.Task) map[string][]string {
+	groupTasks := make(map[string][]string)
+	for _, group := range job.TaskGroups {
+		for _, task := range group.Tasks {
+			for _, agentTask := range tasks {
+				if task == agentTask {
+					groupTasks[*group.Name] = append(groupTasks[*group.Name], task.Name)
+				}
+			}
+		}
+	}
+
+	return groupTasks
+}
+
+func failDeployment(nomadCli *api.Client, deployment *api.Deployment) {
+	log.Warn().
+		Str("deployment", deployment.ID).
+		Msg("Failing the canary deployment")
+
+	_, _, err := nomadCli.Deployments().Fail(deployment.ID, &api.WriteOptions{Namespace: deployment.Namespace})
+	if err != nil {
+		log.Err(err).
+			Str("deployment", deployment.ID).
+			Msg("Unable to fail the deployment, please fail it manually")
+	}
+}
@@ -0,0 +1,63 @@
+package nomad
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/nomad/api"
+	"github.com/pkg/errors"
+)
+
+func TestWaitForCanariesUpdateID(t *testing.T) {
+	deployment := &api.Deployment{
+		TaskGroups: map[string]*api.DeploymentState{
+			"agent": {PlacedCanaries: []string{"canary-1"}},
+		},
+	}
+	groupTasks := map[string][]string{"agent": {"agent"}}
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name     string
+		ctx      context.Context
+		updateID string
+		wantErr  bool
+	}{
+		{
+			name:     "canary reports the update ID",
+			ctx:      context.Background(),
+			updateID: "schedule-2",
+		},
+		{
+			name:     "canary reports another update ID",
+			ctx:      context.Background(),
+			updateID: "schedule-1",
+			wantErr:  true,
+		},
+		{
+			name:     "timeout before the canary reports the update ID",
+			ctx:      cancelled,
+			updateID: "",
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			readUpdateID := func(ctx context.Context, allocationID, taskName string) (string, error) {
+				return tt.updateID, nil
+			}
+
+			err := waitForCanariesUpdateID(tt.ctx, deployment, groupTasks, "schedule-2", readUpdateID)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("waitForCanariesUpdateID() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if tt.wantErr && !errors.Is(err, errCanaryFailed) {
+				t.Errorf("waitForCanariesUpdateID() error = %v, want %v", err, errCanaryFailed)
+			}
+		})
+	}
+}
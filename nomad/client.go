@@ -0,0 +1,80 @@
+package nomad
+
+import (
+	"os"
+	"strings"
+
+	"github.com/hashicorp/nomad/api"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+// GetClient returns a Nomad client configured from the environment.
+// The standard NOMAD_CACERT, NOMAD_CAPATH, NOMAD_CLIENT_CERT, NOMAD_CLIENT_KEY, NOMAD_TLS_SERVER_NAME
+// and NOMAD_SKIP_VERIFY variables are read by the Nomad API, the certificate content variables take precedence over them
+func GetClient() (*api.Client, error) {
+	nomadConfig, err := configFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	nomadCli, err := api.NewClient(nomadConfig)
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to initialize Nomad client")
+	}
+
+	return nomadCli, nil
+}
+
+// configFromEnv returns the Nomad client config of the environment with the certificate contents applied
+func configFromEnv() (*api.Config, error) {
+	nomadConfig := api.DefaultConfig()
+
+	tls := nomadConfig.TLSConfig
+	if tls == nil {
+		tls = &api.TLSConfig{}
+		nomadConfig.TLSConfig = tls
+	}
+
+	if content := os.Getenv(NomadCACertContentEnvVarName); content != "" {
+		// the CA file and directory are preferred by the Nomad API when set
+		tls.CACert, tls.CAPath = "", ""
+		tls.CACertPEM = []byte(content)
+	}
+
+	certContent := os.Getenv(NomadClientCertContentEnvVarName)
+	keyContent := os.Getenv(NomadClientKeyContentEnvVarName)
+	if certContent != "" || keyContent != "" {
+		if certContent == "" || keyContent == "" {
+			return nil, errors.Errorf("both %s and %s are required for mTLS", NomadClientCertContentEnvVarName, NomadClientKeyContentEnvVarName)
+		}
+
+		// the file paths are preferred by the Nomad API when both are set
+		tls.ClientCert, tls.ClientKey = "", ""
+		tls.ClientCertPEM = []byte(certContent)
+		tls.ClientKeyPEM = []byte(keyContent)
+	}
+
+	if (tls.ClientCert == "") != (tls.ClientKey == "") {
+		return nil, errors.New("both NOMAD_CLIENT_CERT and NOMAD_CLIENT_KEY are required for mTLS")
+	}
+
+	if tls.Insecure {
+		log.Warn().
+			Str("address", nomadConfig.Address).
+			Msg("TLS verification of the Nomad server is disabled")
+	}
+
+	if !strings.HasPrefix(nomadConfig.Address, "https") && hasTLSMaterial(tls) {
+		log.Warn().
+			Str("address", nomadConfig.Address).
+			Msg("TLS certificates are configured but the Nomad address doesn't use https")
+	}
+
+	return nomadConfig, nil
+}
+
+func hasTLSMaterial(tls *api.TLSConfig) bool {
+	return tls.CACert != "" || tls.CAPath != "" || len(tls.CACertPEM) > 0 ||
+		tls.ClientCert != "" || len(tls.ClientCertPEM) > 0
+}
@@ -0,0 +1,23 @@
+package nomad
+
+import "testing"
+
+func TestConfigFromEnvCACertContent(t *testing.T) {
+	t.Setenv("NOMAD_ADDR", "https://nomad.local:4646")
+	t.Setenv("NOMAD_CACERT", "/certs/ca.pem")
+	t.Setenv("NOMAD_CAPATH", "/certs")
+	t.Setenv(NomadCACertContentEnvVarName, "-----BEGIN CERTIFICATE-----")
+
+	config, err := configFromEnv()
+	if err != nil {
+		t.Fatalf("configFromEnv() error = %v", err)
+	}
+
+	if config.TLSConfig.CACert != "" || config.TLSConfig.CAPath != "" {
+		t.Errorf("configFromEnv() CACert = %q, CAPath = %q, want both empty", config.TLSConfig.CACert, config.TLSConfig.CAPath)
+	}
+
+	if string(config.TLSConfig.CACertPEM) != "-----BEGIN CERTIFICATE-----" {
+		t.Errorf("configFromEnv() CACertPEM = %q, want the content", config.TLSConfig.CACertPEM)
+	}
+}
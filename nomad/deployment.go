@@ -0,0 +1,268 @@
+package nomad
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/nomad/api"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	evalStatusComplete  = "complete"
+	evalStatusFailed    = "failed"
+	evalStatusCancelled = "canceled"
+
+	deploymentStatusSuccessful = "successful"
+	deploymentStatusFailed     = "failed"
+	deploymentStatusCancelled  = "cancelled"
+
+	// blockingQueryWaitTime is the maximum duration of a blocking query before it returns without changes
+	blockingQueryWaitTime = 30 * time.Second
+)
+
+var (
+	errDeploymentFailed = errors.New("deployment failed")
+	errAllocationFailed = errors.New("allocation failed")
+	errTimeout          = errors.New("timeout")
+)
+
+// waitForRollout waits for the job version registered with jobModifyIndex to be rolled out, following its deployment
+// or its allocations for jobs that don't create deployments (e.g. system jobs)
+func waitForRollout(ctx context.Context, nomadCli *api.Client, job *api.Job, tasks []*api.Task, scheduleId string, evalID string, jobModifyIndex uint64, options UpdateOptions) error {
+	ctx, cancel := context.WithTimeout(ctx, options.Timeout)
+	defer cancel()
+
+	err := waitForEvaluation(ctx, nomadCli, *job.Namespace, evalID)
+	if err != nil {
+		return err
+	}
+
+	deployment, _, err := nomadCli.Jobs().LatestDeployment(*job.ID, queryOptions(ctx, *job.Namespace, 0))
+	if err != nil {
+		return errors.WithMessage(err, "failed to get job deployment")
+	}
+
+	if deployment == nil || deployment.JobSpecModifyIndex != jobModifyIndex {
+		log.Debug().
+			Str("job", *job.ID).
+			Msg("No deployment created for the job, waiting for its allocations")
+
+		return waitForAllocations(ctx, nomadCli, job, tasks, jobModifyIndex)
+	}
+
+	if options.Canary {
+		return runCanaryDeployment(ctx, nomadCli, job, deployment, tasks, scheduleId)
+	}
+
+	return waitForDeployment(ctx, nomadCli, deployment, tasks)
+}
+
+func waitForEvaluation(ctx context.Context, nomadCli *api.Client, namespace, evalID string) error {
+	if evalID == "" {
+		return nil
+	}
+
+	index := uint64(0)
+	for {
+		eval, meta, err := nomadCli.Evaluations().Info(evalID, queryOptions(ctx, namespace, index))
+		if err != nil {
+			if ctx.Err() != nil {
+				return errTimeout
+			}
+
+			return errors.WithMessage(err, "failed to get evaluation")
+		}
+
+		index = meta.LastIndex
+
+		switch eval.Status {
+		case evalStatusComplete:
+			for group, metric := range eval.FailedTGAllocs {
+				log.Error().
+					Str("group", group).
+					Interface("constraints", metric.ConstraintFiltered).
+					Interface("exhausted", metric.DimensionExhausted).
+					Msg("Unable to place allocations for task group")
+			}
+
+			return nil
+		case evalStatusFailed, evalStatusCancelled:
+			return errors.Errorf("evaluation %s: %s", eval.Status, eval.StatusDescription)
+		}
+	}
+}
+
+func waitForDeployment(ctx context.Context, nomadCli *api.Client, deployment *api.Deployment, tasks []*api.Task) error {
+	log.Info().
+		Str("deployment", deployment.ID).
+		Str("job", deployment.JobID).
+		Uint64("jobVersion", deployment.JobVersion).
+		Msg("Waiting for deployment to complete")
+
+	index := uint64(0)
+	for {
+		current, meta, err := nomadCli.Deployments().Info(deployment.ID, queryOptions(ctx, deployment.Namespace, index))
+		if err != nil {
+			if ctx.Err() != nil {
+				return errTimeout
+			}
+
+			return errors.WithMessage(err, "failed to get deployment")
+		}
+
+		index = meta.LastIndex
+
+		for group, state := range current.TaskGroups {
+			log.Debug().
+				Str("deployment", current.ID).
+				Str("group", group).
+				Int("desired", state.DesiredTotal).
+				Int("placed", state.PlacedAllocs).
+				Int("healthy", state.HealthyAllocs).
+				Int("unhealthy", state.UnhealthyAllocs).
+				Msg("Deployment progress")
+		}
+
+		switch current.Status {
+		case deploymentStatusSuccessful:
+			log.Info().
+				Str("deployment", current.ID).
+				Msg("Deployment successful")
+
+			return nil
+		case deploymentStatusFailed, deploymentStatusCancelled:
+			printDeploymentFailures(ctx, nomadCli, current, tasks)
+
+			return errors.WithMessagef(errDeploymentFailed, "%s: %s", current.Status, current.StatusDescription)
+		}
+	}
+}
+
+func printDeploymentFailures(ctx context.Context, nomadCli *api.Client, deployment *api.Deployment, tasks []*api.Task) {
+	autoRevert := false
+	for _, state := range deployment.TaskGroups {
+		autoRevert = autoRevert || state.AutoRevert
+	}
+
+	if autoRevert {
+		log.Warn().
+			Str("deployment", deployment.ID).
+			Str("description", deployment.StatusDescription).
+			Msg("Deployment failed, Nomad reverts the job to its last stable version")
+	} else {
+		log.Warn().
+			Str("deployment", deployment.ID).
+			Str("description", deployment.StatusDescription).
+			Msg("Deployment failed and auto_revert is disabled, the job stays at the failed version")
+	}
+
+	allocations, _, err := nomadCli.Deployments().Allocations(deployment.ID, queryOptions(context.Background(), deployment.Namespace, 0))
+	if err != nil {
+		log.Err(err).Msg("Unable to list deployment allocations")
+		return
+	}
+
+	for _, stub := range allocations {
+		if stub.DeploymentStatus != nil && stub.DeploymentStatus.Healthy != nil && *stub.DeploymentStatus.Healthy {
+			continue
+		}
+
+		printAllocationLogs(nomadCli, deployment.Namespace, stub.ID, tasks)
+	}
+}
+
+// waitForAllocations waits for every allocation of the registered job version to run
+func waitForAllocations(ctx context.Context, nomadCli *api.Client, job *api.Job, tasks []*api.Task, jobModifyIndex uint64) error {
+	registered, _, err := nomadCli.Jobs().Info(*job.ID, queryOptions(ctx, *job.Namespace, 0))
+	if err != nil {
+		return errors.WithMessage(err, "failed to get job info")
+	}
+
+	index := jobModifyIndex
+	for {
+		allocations, meta, err := nomadCli.Jobs().Allocations(*job.ID, false, queryOptions(ctx, *job.Namespace, index))
+		if err != nil {
+			if ctx.Err() != nil {
+				return errTimeout
+			}
+
+			return errors.WithMessage(err, "failed to get allocations for job")
+		}
+
+		index = meta.LastIndex
+
+		running, pending := 0, 0
+		for _, allocation := range allocations {
+			if allocation.JobVersion != *registered.Version || allocation.DesiredStatus != api.AllocDesiredStatusRun {
+				continue
+			}
+
+			switch allocation.ClientStatus {
+			case api.AllocClientStatusRunning:
+				running++
+			case api.AllocClientStatusPending:
+				pending++
+			default:
+				printAllocationLogs(nomadCli, *job.Namespace, allocation.ID, tasks)
+
+				return errors.WithMessagef(errAllocationFailed, "allocation %s is %s", allocation.ID, allocation.ClientStatus)
+			}
+		}
+
+		log.Debug().
+			Str("job", *job.ID).
+			Int("running", running).
+			Int("pending", pending).
+			Msg("Waiting for allocations to run")
+
+		if running > 0 && pending == 0 {
+			log.Info().
+				Str("job", *job.ID).
+				Int("allocations", running).
+				Msg("Allocations running")
+
+			return nil
+		}
+	}
+}
+
+func printAllocationLogs(nomadCli *api.Client, namespace, allocationID string, tasks []*api.Task) {
+	allocation, _, err := nomadCli.Allocations().Info(allocationID, &api.QueryOptions{Namespace: namespace})
+	if err != nil {
+		log.Err(err).
+			Str("allocation", allocationID).
+			Msg("Unable to get allocation info")
+		return
+	}
+
+	for name, state := range allocation.TaskStates {
+		for _, event := range state.Events {
+			if event.FailsTask || strings.Contains(event.Type, "Failed") {
+				log.Error().
+					Str("allocation", allocation.ID).
+					Str("task", name).
+					Str("event", event.Type).
+					Str("message", event.DisplayMessage).
+					Msg("Task event")
+			}
+		}
+	}
+
+	err = readTasksLogs(nomadCli, allocation, tasks)
+	if err != nil {
+		log.Info().
+			Err(err).
+			Msg("failed to read logs")
+	}
+}
+
+func queryOptions(ctx context.Context, namespace string, waitIndex uint64) *api.QueryOptions {
+	return (&api.QueryOptions{
+		Namespace: namespace,
+		WaitIndex: waitIndex,
+		WaitTime:  blockingQueryWaitTime,
+	}).WithContext(ctx)
+}
@@ -6,40 +6,105 @@ import (
 
 	"github.com/hashicorp/nomad/api"
 	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
 )
 
-func FindAgentContainer(ctx context.Context, nomadCli *api.Client) (job *api.Job, task *api.Task, err error) {
-	jobs, _, err := nomadCli.Jobs().List(nil)
+// AgentJob is a job running Portainer agent tasks
+type AgentJob struct {
+	Job   *api.Job
+	Tasks []*api.Task
+}
+
+// FindOptions narrows down the search of the agent jobs
+type FindOptions struct {
+	// Namespace is the namespace to search, all namespaces are searched when empty
+	Namespace string
+	// JobID restricts the search to a single job
+	JobID string
+	// ImagePrefixes are additional image prefixes identifying an agent task
+	ImagePrefixes []string
+}
+
+var agentImagePrefixes = []string{"portainer/agent", "portainerci/agent"}
+
+// FindAgentJobs returns every running service or system job with docker tasks running the agent image
+func FindAgentJobs(ctx context.Context, nomadCli *api.Client, options FindOptions) ([]AgentJob, error) {
+	namespace := options.Namespace
+	if namespace == "" {
+		namespace = api.AllNamespacesNamespace
+	}
+
+	imagePrefixes := append(agentImagePrefixes, options.ImagePrefixes...)
+
+	queryOptions := (&api.QueryOptions{Namespace: namespace}).WithContext(ctx)
+	if options.JobID != "" {
+		queryOptions.Prefix = options.JobID
+	}
+
+	jobs, _, err := nomadCli.Jobs().List(queryOptions)
 	if err != nil {
-		return nil, nil, errors.WithMessage(err, "failed listing jobs")
+		return nil, errors.WithMessage(err, "failed listing jobs")
 	}
 
+	var agentJobs []AgentJob
 	for _, jobSummary := range jobs {
-		if jobSummary.Type != "service" {
+		if jobSummary.Type != api.JobTypeService && jobSummary.Type != api.JobTypeSystem {
+			continue
+		}
+
+		if options.JobID != "" && jobSummary.ID != options.JobID {
 			continue
 		}
-		job, _, err := nomadCli.Jobs().Info(jobSummary.ID, nil)
+
+		job, _, err := nomadCli.Jobs().Info(jobSummary.ID, (&api.QueryOptions{Namespace: jobSummary.Namespace}).WithContext(ctx))
 		if err != nil {
-			return nil, nil, errors.WithMessage(err, "failed to get job info")
+			return nil, errors.WithMessage(err, "failed to get job info")
 		}
 
 		if *job.Status != "running" {
 			continue
 		}
 
+		var tasks []*api.Task
 		for _, group := range job.TaskGroups {
 			for _, task := range group.Tasks {
 				if task.Driver != "docker" {
 					continue
 				}
 
-				if taskImage, ok := task.Config["image"].(string); ok && (strings.HasPrefix(taskImage, "portainer/agent") || strings.HasPrefix(taskImage, "portainerci/agent")) {
-					return job, task, nil
+				if taskImage, ok := task.Config["image"].(string); ok && hasImagePrefix(taskImage, imagePrefixes) {
+					tasks = append(tasks, task)
 				}
 			}
 		}
 
+		if len(tasks) == 0 {
+			continue
+		}
+
+		log.Debug().
+			Str("job", *job.ID).
+			Str("namespace", *job.Namespace).
+			Str("type", *job.Type).
+			Int("tasks", len(tasks)).
+			Msg("Found agent job")
+
+		agentJobs = append(agentJobs, AgentJob{Job: job, Tasks: tasks})
+	}
+
+	if len(agentJobs) == 0 {
+		return nil, errors.New("no agent container found")
+	}
+
+	return agentJobs, nil
+}
+
+func hasImagePrefix(image string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(image, prefix) {
+			return true
+		}
 	}
 
-	return nil, nil, errors.New("no agent container found")
+	return false
 }
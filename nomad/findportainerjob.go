@@ -0,0 +1,126 @@
+package nomad
+
+import (
+	"context"
+	"strings"
+
+	"github.com/hashicorp/nomad/api"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+// PortainerServerMetaKey is the meta key marking the job, group or task running the Portainer server
+const PortainerServerMetaKey = "io.portainer.server"
+
+var portainerImagePrefixes = []string{"portainer/portainer-ce", "portainer/portainer-ee", "portainerci/portainer"}
+
+// FindPortainerJob returns the service job running the Portainer server and its server tasks.
+// Tasks marked with the io.portainer.server meta are preferred over the tasks matched by image
+func FindPortainerJob(ctx context.Context, nomadCli *api.Client, options FindOptions) (*api.Job, []*api.Task, error) {
+	namespace := options.Namespace
+	if namespace == "" {
+		namespace = api.AllNamespacesNamespace
+	}
+
+	imagePrefixes := append(portainerImagePrefixes, options.ImagePrefixes...)
+
+	queryOptions := (&api.QueryOptions{Namespace: namespace}).WithContext(ctx)
+	if options.JobID != "" {
+		queryOptions.Prefix = options.JobID
+	}
+
+	jobs, _, err := nomadCli.Jobs().List(queryOptions)
+	if err != nil {
+		return nil, nil, errors.WithMessage(err, "failed listing jobs")
+	}
+
+	var (
+		metaJob, imageJob     *api.Job
+		metaTasks, imageTasks []*api.Task
+		ambiguous             []string
+	)
+
+	for _, jobSummary := range jobs {
+		if jobSummary.Type != api.JobTypeService {
+			continue
+		}
+
+		if options.JobID != "" && jobSummary.ID != options.JobID {
+			continue
+		}
+
+		job, _, err := nomadCli.Jobs().Info(jobSummary.ID, (&api.QueryOptions{Namespace: jobSummary.Namespace}).WithContext(ctx))
+		if err != nil {
+			return nil, nil, errors.WithMessage(err, "failed to get job info")
+		}
+
+		if *job.Status != "running" {
+			continue
+		}
+
+		var byMeta, byImage []*api.Task
+		for _, group := range job.TaskGroups {
+			for _, task := range group.Tasks {
+				if task.Driver != "docker" {
+					continue
+				}
+
+				if isPortainerServer(job.Meta) || isPortainerServer(group.Meta) || isPortainerServer(task.Meta) {
+					byMeta = append(byMeta, task)
+					continue
+				}
+
+				if taskImage, ok := task.Config["image"].(string); ok && hasImagePrefix(taskImage, imagePrefixes) {
+					byImage = append(byImage, task)
+				}
+			}
+		}
+
+		if len(byMeta) > 0 {
+			if metaJob != nil {
+				return nil, nil, errors.Errorf("multiple jobs are marked with the %s meta: %s and %s", PortainerServerMetaKey, *metaJob.ID, *job.ID)
+			}
+
+			metaJob, metaTasks = job, byMeta
+		}
+
+		if len(byImage) > 0 {
+			if imageJob != nil {
+				ambiguous = append(ambiguous, *job.ID)
+				continue
+			}
+
+			imageJob, imageTasks = job, byImage
+		}
+	}
+
+	if metaJob != nil {
+		log.Debug().
+			Str("job", *metaJob.ID).
+			Str("namespace", *metaJob.Namespace).
+			Int("tasks", len(metaTasks)).
+			Msg("Found Portainer job by meta")
+
+		return metaJob, metaTasks, nil
+	}
+
+	if imageJob != nil {
+		if len(ambiguous) > 0 {
+			return nil, nil, errors.Errorf("multiple jobs run the Portainer image (%s and %s), select one with its job ID or the %s meta", *imageJob.ID, strings.Join(ambiguous, ", "), PortainerServerMetaKey)
+		}
+
+		log.Debug().
+			Str("job", *imageJob.ID).
+			Str("namespace", *imageJob.Namespace).
+			Int("tasks", len(imageTasks)).
+			Msg("Found Portainer job by image")
+
+		return imageJob, imageTasks, nil
+	}
+
+	return nil, nil, errors.New("no Portainer job found")
+}
+
+func isPortainerServer(meta map[string]string) bool {
+	return meta[PortainerServerMetaKey] == "true"
+}
@@ -24,3 +24,11 @@ const (
 
 	EnvKeyUpdateID = "UPDATE_ID"
 )
+
+// SensitiveEnvVarNames are the env variables stored in the job variable instead of the job spec
+var SensitiveEnvVarNames = []string{
+	NomadTokenEnvVarName,
+	NomadClientKeyContentEnvVarName,
+	EnvKeyEdgeKey,
+	EnvKeyAgentSecret,
+}
@@ -0,0 +1,153 @@
+package nomad
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/nomad/api"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	diffTypeNone = "None"
+
+	// maskedValue replaces the values of the env fields in the logged diff
+	maskedValue = "<redacted>"
+)
+
+var errPlanFailed = errors.New("job plan failed")
+
+// fieldChange is a changed field of the job plan diff
+type fieldChange struct {
+	Type string
+	Path string
+	Old  string
+	New  string
+}
+
+// planJob plans the registration of the job and logs its changes,
+// it fails when the scheduler can't place the allocations of a task group
+func planJob(ctx context.Context, nomadCli *api.Client, job *api.Job) error {
+	plan, _, err := nomadCli.Jobs().Plan(job, true, (&api.WriteOptions{Namespace: *job.Namespace}).WithContext(ctx))
+	if err != nil {
+		return errors.WithMessage(err, "failed to plan job")
+	}
+
+	for _, change := range flattenJobDiff(plan.Diff) {
+		log.Info().
+			Str("job", *job.ID).
+			Str("type", change.Type).
+			Str("field", change.Path).
+			Str("old", change.Old).
+			Str("new", change.New).
+			Msg("Job plan change")
+	}
+
+	if plan.Annotations != nil {
+		for group, updates := range plan.Annotations.DesiredTGUpdates {
+			log.Debug().
+				Str("job", *job.ID).
+				Str("group", group).
+				Uint64("place", updates.Place).
+				Uint64("inPlaceUpdate", updates.InPlaceUpdate).
+				Uint64("destructiveUpdate", updates.DestructiveUpdate).
+				Uint64("canary", updates.Canary).
+				Uint64("stop", updates.Stop).
+				Msg("Job plan allocations")
+		}
+	}
+
+	if len(plan.FailedTGAllocs) == 0 {
+		return nil
+	}
+
+	for group, metric := range plan.FailedTGAllocs {
+		log.Error().
+			Str("job", *job.ID).
+			Str("group", group).
+			Int("nodesEvaluated", metric.NodesEvaluated).
+			Interface("constraints", metric.ConstraintFiltered).
+			Interface("exhausted", metric.DimensionExhausted).
+			Msg("Job plan unable to place allocations for task group")
+	}
+
+	return errors.WithMessagef(errPlanFailed, "unable to place allocations for %d task groups", len(plan.FailedTGAllocs))
+}
+
+// flattenJobDiff returns the changed fields of the diff, the env values are masked
+func flattenJobDiff(diff *api.JobDiff) []fieldChange {
+	if diff == nil {
+		return nil
+	}
+
+	var changes []fieldChange
+
+	path := "Job"
+	changes = appendFieldChanges(changes, path, diff.Fields, false)
+	changes = appendObjectChanges(changes, path, diff.Objects, false)
+
+	for _, group := range diff.TaskGroups {
+		groupPath := fmt.Sprintf("%s/TaskGroup[%s]", path, group.Name)
+		changes = appendFieldChanges(changes, groupPath, group.Fields, false)
+		changes = appendObjectChanges(changes, groupPath, group.Objects, false)
+
+		for _, task := range group.Tasks {
+			taskPath := fmt.Sprintf("%s/Task[%s]", groupPath, task.Name)
+			changes = appendFieldChanges(changes, taskPath, task.Fields, false)
+			changes = appendObjectChanges(changes, taskPath, task.Objects, false)
+		}
+	}
+
+	return changes
+}
+
+func appendObjectChanges(changes []fieldChange, path string, objects []*api.ObjectDiff, masked bool) []fieldChange {
+	for _, object := range objects {
+		if object.Type == diffTypeNone {
+			continue
+		}
+
+		objectPath := path + "/" + object.Name
+		objectMasked := masked || object.Name == "Env"
+
+		changes = appendFieldChanges(changes, objectPath, object.Fields, objectMasked)
+		changes = appendObjectChanges(changes, objectPath, object.Objects, objectMasked)
+	}
+
+	return changes
+}
+
+func appendFieldChanges(changes []fieldChange, path string, fields []*api.FieldDiff, masked bool) []fieldChange {
+	for _, field := range fields {
+		if field.Type == diffTypeNone {
+			continue
+		}
+
+		change := fieldChange{
+			Type: field.Type,
+			Path: path + "/" + field.Name,
+			Old:  field.Old,
+			New:  field.New,
+		}
+
+		// the task env is diffed as flattened Env[NAME] fields
+		if masked || strings.HasPrefix(field.Name, "Env[") {
+			change.Old = maskValue(change.Old)
+			change.New = maskValue(change.New)
+		}
+
+		changes = append(changes, change)
+	}
+
+	return changes
+}
+
+func maskValue(value string) string {
+	if value == "" {
+		return ""
+	}
+
+	return maskedValue
+}
@@ -0,0 +1,98 @@
+package nomad
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/nomad/api"
+)
+
+func TestFlattenJobDiff(t *testing.T) {
+	tests := []struct {
+		name string
+		diff *api.JobDiff
+		want []fieldChange
+	}{
+		{
+			name: "no diff",
+			diff: nil,
+			want: nil,
+		},
+		{
+			name: "image change",
+			diff: &api.JobDiff{
+				Type: "Edited",
+				TaskGroups: []*api.TaskGroupDiff{
+					{
+						Type: "Edited",
+						Name: "agent",
+						Tasks: []*api.TaskDiff{
+							{
+								Type: "Edited",
+								Name: "agent",
+								Objects: []*api.ObjectDiff{
+									{
+										Type: "Edited",
+										Name: "Config",
+										Fields: []*api.FieldDiff{
+											{Type: "Edited", Name: "image", Old: "portainer/agent:2.18.1", New: "portainer/agent:2.19.0"},
+											{Type: "None", Name: "privileged", Old: "true", New: "true"},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+			want: []fieldChange{
+				{Type: "Edited", Path: "Job/TaskGroup[agent]/Task[agent]/Config/image", Old: "portainer/agent:2.18.1", New: "portainer/agent:2.19.0"},
+			},
+		},
+		{
+			name: "env values are masked",
+			diff: &api.JobDiff{
+				Type: "Edited",
+				TaskGroups: []*api.TaskGroupDiff{
+					{
+						Type: "Edited",
+						Name: "agent",
+						Tasks: []*api.TaskDiff{
+							{
+								Type: "Edited",
+								Name: "agent",
+								Fields: []*api.FieldDiff{
+									{Type: "Deleted", Name: "Env[NOMAD_TOKEN]", Old: "secret"},
+								},
+								Objects: []*api.ObjectDiff{
+									{
+										Type: "Edited",
+										Name: "Env",
+										Fields: []*api.FieldDiff{
+											{Type: "Deleted", Name: "EDGE_KEY", Old: "secret"},
+											{Type: "Added", Name: "UPDATE_ID", New: "2"},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+			want: []fieldChange{
+				{Type: "Deleted", Path: "Job/TaskGroup[agent]/Task[agent]/Env[NOMAD_TOKEN]", Old: maskedValue},
+				{Type: "Deleted", Path: "Job/TaskGroup[agent]/Task[agent]/Env/EDGE_KEY", Old: maskedValue},
+				{Type: "Added", Path: "Job/TaskGroup[agent]/Task[agent]/Env/UPDATE_ID", New: maskedValue},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := flattenJobDiff(tt.diff)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("flattenJobDiff() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
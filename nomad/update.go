@@ -5,6 +5,7 @@ import (
 	"io"
 	"os"
 	"os/signal"
+	"sort"
 	"syscall"
 	"time"
 
@@ -13,24 +14,66 @@ import (
 	"github.com/rs/zerolog/log"
 )
 
-func Update(ctx context.Context, nomadCli *api.Client, job *api.Job, task *api.Task, imageName string, scheduleId string) error {
+func Update(ctx context.Context, nomadCli *api.Client, job *api.Job, tasks []*api.Task, imageName string, scheduleId string, options UpdateOptions) error {
 
 	log.Info().
 		Str("image", imageName).
-		Str("task", task.Name).
+		Str("job", *job.ID).
+		Str("namespace", *job.Namespace).
+		Int("tasks", len(tasks)).
 		Str("schedule-id", scheduleId).
-		Msg("Updating Portainer agent")
+		Msg("Updating Nomad job")
 
-	log.Debug().
-		Interface("task config", task.Config).
-		Interface("task env", task.Env).
-		Msg("Portainer agent configuration")
+	for _, task := range tasks {
+		log.Debug().
+			Str("task", task.Name).
+			Interface("task config", task.Config).
+			Strs("task env", envNames(task.Env)).
+			Msg("Task configuration")
+
+		task.Config["image"] = imageName
+	}
+
+	if options.Canary && *job.Type != api.JobTypeService {
+		log.Warn().
+			Str("job", *job.ID).
+			Str("type", *job.Type).
+			Msg("Canary updates are only supported by service jobs, updating without canary")
+
+		options.Canary = false
+	}
 
-	job.Update = api.DefaultUpdateStrategy()
-	task.Config["image"] = imageName
+	originalStrategies := copyUpdateStrategies(job)
 
-	response, _, err := nomadCli.Jobs().EnforceRegister(job, *job.JobModifyIndex, nil)
+	overridden, err := applyUpdateOverrides(job, options)
 	if err != nil {
+		return errors.WithMessage(err, "invalid update stanza override")
+	}
+
+	variable, err := prepareJobVariable(ctx, nomadCli, job, tasks, options.SecretEnv)
+	if err != nil {
+		return err
+	}
+
+	err = planJob(ctx, nomadCli, job)
+	if err != nil {
+		return err
+	}
+
+	// the variable is written once the plan succeeded so a refused job doesn't leave it changed
+	if variable != nil {
+		err = variable.write(ctx, nomadCli)
+		if err != nil {
+			return err
+		}
+	}
+
+	response, _, err := nomadCli.Jobs().EnforceRegister(job, *job.JobModifyIndex, (&api.WriteOptions{Namespace: *job.Namespace}).WithContext(ctx))
+	if err != nil {
+		if variable != nil {
+			variable.rollback(ctx, nomadCli)
+		}
+
 		return errors.WithMessage(err, "failed to register job")
 	}
 
@@ -39,86 +82,57 @@ func Update(ctx context.Context, nomadCli *api.Client, job *api.Job, task *api.T
 		Str("warnings", response.Warnings).
 		Msg("Job registered")
 
-	allocations, _, err := nomadCli.Jobs().Allocations(*job.ID, false, &api.QueryOptions{WaitIndex: response.JobModifyIndex})
-	if err != nil {
-		return errors.WithMessage(err, "failed to get allocations for job")
+	err = waitForRollout(ctx, nomadCli, job, tasks, scheduleId, response.EvalID, response.JobModifyIndex, options)
+
+	if overridden {
+		// registering the job again while a deployment or an auto revert is in flight would cancel it
+		if rolloutSettled(job, err) {
+			restoreUpdateStrategies(ctx, nomadCli, *job.ID, *job.Namespace, originalStrategies)
+		} else {
+			logUpdateStrategies(*job.ID, originalStrategies)
+		}
 	}
 
-	log.Info().
-		Int("allocation number", len(allocations)).
-		Str("job id", *job.ID).
-		Msg("Listing job allocations")
-
-	for _, allocation := range allocations {
-		if allocation.ClientStatus != api.AllocClientStatusPending {
-			if allocation.ClientStatus == api.AllocClientStatusRunning {
-				// There is a case that the status of the target allocation can change
-				// from Pending to Running before listing allocations
-				log.Info().
-					Str("allocation", allocation.ID).
-					Str("status", allocation.ClientStatus).
-					Msg("Allocation success")
-				return nil
-			}
+	return err
+}
+
+// rolloutSettled returns true when no deployment of the job can still be running after the rollout returned err
+func rolloutSettled(job *api.Job, err error) bool {
+	switch {
+	case err == nil, errors.Is(err, errAllocationFailed):
+		return true
+	case errors.Is(err, errDeploymentFailed):
+		return !autoReverts(job)
+	}
+
+	return false
+}
+
+// envNames returns the names of the env variables, their values may be sensitive and are never logged
+func envNames(env map[string]string) []string {
+	names := make([]string, 0, len(env))
+	for name := range env {
+		names = append(names, name)
+	}
 
+	sort.Strings(names)
+
+	return names
+}
+
+func readTasksLogs(nomadCli *api.Client, allocation *api.Allocation, tasks []*api.Task) error {
+	for _, task := range tasks {
+		if _, ok := allocation.TaskStates[task.Name]; !ok {
 			continue
 		}
 
-		log.Info().
-			Str("allocation", allocation.ID).
-			Msg("waiting for allocation to start")
-
-		for {
-			time.Sleep(1 * time.Second)
-
-			log.Debug().
-				Str("allocation", allocation.ID).
-				Msg("polling allocation")
-
-			allocation, _, err := nomadCli.Allocations().Info(allocation.ID, nil)
-			if err != nil {
-				return errors.WithMessage(err, "failed to get allocation info")
-			}
-
-			if allocation.ClientStatus == api.AllocClientStatusPending {
-				continue
-			}
-
-			if allocation.ClientStatus == api.AllocClientStatusRunning {
-				log.Info().
-					Str("allocation", allocation.ID).
-					Str("status", allocation.ClientStatus).
-					Msg("Allocation success")
-				return nil
-			}
-
-			err = readLogs(nomadCli, allocation, task)
-			if err != nil {
-				log.Info().
-					Err(err).
-					Msg("failed to read logs")
-
-				// suppress the read log error to avoid updater exit with non-0 status
-				continue
-			}
-
-			if allocation.ClientStatus == api.AllocClientStatusFailed {
-				return errors.New("allocation failed")
-			}
-
-			if allocation.ClientStatus == api.AllocClientStatusLost {
-				return errors.New("allocation lost")
-			}
-
-			if allocation.ClientStatus == api.AllocClientStatusComplete {
-				return errors.New("allocation complete")
-			}
-
-			return errors.New("unknown allocation status")
+		err := readLogs(nomadCli, allocation, task)
+		if err != nil {
+			return err
 		}
 	}
 
-	return errors.New("no allocations found")
+	return nil
 }
 
 func readLogs(nomadCli *api.Client, allocation *api.Allocation, task *api.Task) error {
@@ -0,0 +1,220 @@
+package nomad
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/hashicorp/nomad/api"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+// UpdateOptions holds the settings of the agent job update,
+// the update stanza fields are only overridden for the upgrade when set
+type UpdateOptions struct {
+	// Timeout is the maximum duration to wait for the rollout to complete
+	Timeout time.Duration
+	// MaxParallel overrides max_parallel when greater than 0
+	MaxParallel int
+	// HealthCheck overrides health_check, one of checks, task_states or manual
+	HealthCheck string
+	// MinHealthyTime overrides min_healthy_time when greater than 0
+	MinHealthyTime time.Duration
+	// HealthyDeadline overrides healthy_deadline when greater than 0
+	HealthyDeadline time.Duration
+	// AutoRevert overrides auto_revert, true or false
+	AutoRevert string
+	// Canary upgrades a single canary allocation per task group first and promotes the deployment once it's healthy,
+	// auto_revert is enabled unless overridden so failing the canary reverts the job
+	Canary bool
+	// SecretEnv holds the task env stored in the job variable instead of the job spec, empty values keep the stored ones
+	SecretEnv map[string]string
+}
+
+// updateStrategies holds the update stanzas of a job, the job one and the ones of its task groups
+type updateStrategies struct {
+	job    *api.UpdateStrategy
+	groups map[string]*api.UpdateStrategy
+}
+
+func copyUpdateStrategies(job *api.Job) updateStrategies {
+	strategies := updateStrategies{
+		job:    job.Update.Copy(),
+		groups: map[string]*api.UpdateStrategy{},
+	}
+
+	for _, group := range job.TaskGroups {
+		strategies.groups[*group.Name] = group.Update.Copy()
+	}
+
+	return strategies
+}
+
+// applyUpdateOverrides applies the overrides to the job and task groups update stanzas, it returns false when nothing was overridden
+func applyUpdateOverrides(job *api.Job, options UpdateOptions) (bool, error) {
+	override, err := options.updateStrategy()
+	if err != nil {
+		return false, err
+	}
+
+	if override == nil {
+		return false, nil
+	}
+
+	if job.Update == nil {
+		job.Update = &api.UpdateStrategy{}
+	}
+
+	mergeUpdateStrategy(job.Update, override)
+
+	// task group stanzas take precedence over the job one
+	for _, group := range job.TaskGroups {
+		if group.Update != nil {
+			mergeUpdateStrategy(group.Update, override)
+		}
+	}
+
+	return true, nil
+}
+
+func (options UpdateOptions) updateStrategy() (*api.UpdateStrategy, error) {
+	strategy := &api.UpdateStrategy{}
+	overridden := false
+
+	if options.MaxParallel > 0 {
+		strategy.MaxParallel = pointerOf(options.MaxParallel)
+		overridden = true
+	}
+
+	if options.HealthCheck != "" {
+		switch options.HealthCheck {
+		case "checks", "task_states", "manual":
+		default:
+			return nil, errors.Errorf("invalid health check %q, must be one of checks, task_states or manual", options.HealthCheck)
+		}
+
+		strategy.HealthCheck = pointerOf(options.HealthCheck)
+		overridden = true
+	}
+
+	if options.MinHealthyTime > 0 {
+		strategy.MinHealthyTime = pointerOf(options.MinHealthyTime)
+		overridden = true
+	}
+
+	if options.HealthyDeadline > 0 {
+		strategy.HealthyDeadline = pointerOf(options.HealthyDeadline)
+		overridden = true
+	}
+
+	if options.AutoRevert != "" {
+		autoRevert, err := strconv.ParseBool(options.AutoRevert)
+		if err != nil {
+			return nil, errors.WithMessage(err, "invalid auto revert value")
+		}
+
+		strategy.AutoRevert = pointerOf(autoRevert)
+		overridden = true
+	}
+
+	if options.Canary {
+		strategy.Canary = pointerOf(1)
+		strategy.AutoPromote = pointerOf(false)
+		if strategy.AutoRevert == nil {
+			strategy.AutoRevert = pointerOf(true)
+		}
+
+		overridden = true
+	}
+
+	if !overridden {
+		return nil, nil
+	}
+
+	return strategy, nil
+}
+
+func mergeUpdateStrategy(strategy, override *api.UpdateStrategy) {
+	if override.MaxParallel != nil {
+		strategy.MaxParallel = override.MaxParallel
+	}
+
+	if override.HealthCheck != nil {
+		strategy.HealthCheck = override.HealthCheck
+	}
+
+	if override.MinHealthyTime != nil {
+		strategy.MinHealthyTime = override.MinHealthyTime
+	}
+
+	if override.HealthyDeadline != nil {
+		strategy.HealthyDeadline = override.HealthyDeadline
+	}
+
+	if override.AutoRevert != nil {
+		strategy.AutoRevert = override.AutoRevert
+	}
+
+	if override.Canary != nil {
+		strategy.Canary = override.Canary
+	}
+
+	if override.AutoPromote != nil {
+		strategy.AutoPromote = override.AutoPromote
+	}
+}
+
+// restoreUpdateStrategies registers a new version of the job with its original update stanzas,
+// changing them doesn't replace the job allocations
+func restoreUpdateStrategies(ctx context.Context, nomadCli *api.Client, jobID, namespace string, strategies updateStrategies) {
+	log.Debug().
+		Str("job", jobID).
+		Msg("Restoring job update stanza")
+
+	job, _, err := nomadCli.Jobs().Info(jobID, (&api.QueryOptions{Namespace: namespace}).WithContext(ctx))
+	if err != nil {
+		log.Err(err).
+			Str("job", jobID).
+			Msg("Unable to get job info, please restore its update stanza manually")
+		return
+	}
+
+	job.Update = strategies.job
+	for _, group := range job.TaskGroups {
+		if strategy, ok := strategies.groups[*group.Name]; ok {
+			group.Update = strategy
+		}
+	}
+
+	_, _, err = nomadCli.Jobs().EnforceRegister(job, *job.JobModifyIndex, (&api.WriteOptions{Namespace: namespace}).WithContext(ctx))
+	if err != nil {
+		log.Err(err).
+			Str("job", jobID).
+			Msg("Unable to restore job update stanza, please restore it manually")
+	}
+}
+
+// logUpdateStrategies prints the update stanzas the job had before the update when they can't be restored safely
+func logUpdateStrategies(jobID string, strategies updateStrategies) {
+	log.Warn().
+		Str("job", jobID).
+		Interface("update", strategies.job).
+		Interface("groups", strategies.groups).
+		Msg("The job update stanza was not restored, please restore it manually once the deployment is settled")
+}
+
+// autoReverts returns true when a task group of the job reverts a failed deployment
+func autoReverts(job *api.Job) bool {
+	for _, group := range job.TaskGroups {
+		if group.Update != nil && group.Update.AutoRevert != nil && *group.Update.AutoRevert {
+			return true
+		}
+	}
+
+	return job.Update != nil && job.Update.AutoRevert != nil && *job.Update.AutoRevert
+}
+
+func pointerOf[T any](value T) *T {
+	return &value
+}
@@ -0,0 +1,209 @@
+package nomad
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/Masterminds/semver"
+	"github.com/hashicorp/nomad/api"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+// variableTemplateDestPath is the destination of the template exposing the job variable items as task env
+const variableTemplateDestPath = "secrets/portainer-updater.env"
+
+// variablesMinVersion is the first Nomad version supporting variables
+var variablesMinVersion = semver.MustParse("1.4.0")
+
+// jobVariable is the pending write of the job variable holding the sensitive env of the tasks
+type jobVariable struct {
+	variable *api.Variable
+	// previous holds the items before the update, nil when the variable doesn't exist yet
+	previous api.VariableItems
+}
+
+// jobVariablePath returns the path of the job variable, readable by the job tasks without any ACL policy
+func jobVariablePath(job *api.Job) string {
+	return "nomad/jobs/" + *job.ID
+}
+
+// prepareJobVariable merges the items in the job variable and points the env of the tasks to it through a template,
+// the items are removed from the plain-text env of the tasks and empty values don't overwrite the stored ones.
+// The variable is only written by write, nil is returned when there is nothing to store or when the cluster
+// doesn't support variables, the items are then written in the plain-text env of the tasks
+func prepareJobVariable(ctx context.Context, nomadCli *api.Client, job *api.Job, tasks []*api.Task, items map[string]string) (*jobVariable, error) {
+	if len(items) == 0 {
+		return nil, nil
+	}
+
+	path := jobVariablePath(job)
+
+	supported, err := variablesSupported(ctx, nomadCli, *job.Namespace, path)
+	if err != nil {
+		return nil, err
+	}
+
+	if !supported {
+		log.Warn().
+			Str("job", *job.ID).
+			Strs("env", setTasksEnv(tasks, items)).
+			Msg("Nomad variables are not supported by the cluster, writing the env in the job spec")
+
+		return nil, nil
+	}
+
+	// Peek returns nil when the variable doesn't exist
+	current, _, err := nomadCli.Variables().Peek(path, (&api.QueryOptions{Namespace: *job.Namespace}).WithContext(ctx))
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to read job variable")
+	}
+
+	pending := &jobVariable{
+		variable: &api.Variable{
+			Namespace: *job.Namespace,
+			Path:      path,
+			Items:     make(api.VariableItems),
+		},
+	}
+
+	if current != nil {
+		pending.variable.ModifyIndex = current.ModifyIndex
+		pending.previous = make(api.VariableItems, len(current.Items))
+		for key, value := range current.Items {
+			pending.variable.Items[key] = value
+			pending.previous[key] = value
+		}
+	}
+
+	for key, value := range items {
+		if value == "" {
+			continue
+		}
+
+		pending.variable.Items[key] = value
+	}
+
+	// the template blocks the task until the variable exists, nothing is rendered without items
+	if len(pending.variable.Items) == 0 {
+		return nil, nil
+	}
+
+	for _, task := range tasks {
+		for key := range pending.variable.Items {
+			delete(task.Env, key)
+		}
+
+		setVariableTemplate(task, path)
+	}
+
+	return pending, nil
+}
+
+// write stores the items in the job variable, the modify index makes the write fail when the variable changed since it was read
+func (v *jobVariable) write(ctx context.Context, nomadCli *api.Client) error {
+	written, _, err := nomadCli.Variables().CheckedUpdate(v.variable, (&api.WriteOptions{Namespace: v.variable.Namespace}).WithContext(ctx))
+	if err != nil {
+		return errors.WithMessage(err, "failed to write job variable")
+	}
+
+	v.variable.ModifyIndex = written.ModifyIndex
+
+	log.Debug().
+		Str("variable", v.variable.Path).
+		Int("items", len(v.variable.Items)).
+		Msg("Task env stored in job variable")
+
+	return nil
+}
+
+// rollback puts back the job variable as it was before write
+func (v *jobVariable) rollback(ctx context.Context, nomadCli *api.Client) {
+	writeOptions := (&api.WriteOptions{Namespace: v.variable.Namespace}).WithContext(ctx)
+
+	var err error
+	if v.previous == nil {
+		_, err = nomadCli.Variables().CheckedDelete(v.variable.Path, v.variable.ModifyIndex, writeOptions)
+	} else {
+		v.variable.Items = v.previous
+		_, _, err = nomadCli.Variables().CheckedUpdate(v.variable, writeOptions)
+	}
+
+	if err != nil {
+		log.Err(err).
+			Str("variable", v.variable.Path).
+			Msg("Unable to restore the job variable, please restore it manually")
+	}
+}
+
+// variablesSupported returns false when the agent reports a Nomad version older than 1.4 or when the variables endpoint
+// doesn't exist, the version is unknown when the token can't read the agent
+func variablesSupported(ctx context.Context, nomadCli *api.Client, namespace, path string) (bool, error) {
+	self, err := nomadCli.Agent().Self()
+	if err != nil {
+		log.Debug().
+			Err(err).
+			Msg("Unable to read the Nomad agent version")
+	} else if version, err := semver.NewVersion(self.Member.Tags["build"]); err == nil && version.LessThan(variablesMinVersion) {
+		return false, nil
+	}
+
+	_, _, err = nomadCli.Variables().PrefixList(path, (&api.QueryOptions{Namespace: namespace}).WithContext(ctx))
+	if isNotFound(err) {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, errors.WithMessage(err, "failed to list job variables")
+	}
+
+	return true, nil
+}
+
+// setTasksEnv writes the non-empty items in the env of the tasks and returns their names
+func setTasksEnv(tasks []*api.Task, items map[string]string) []string {
+	var names []string
+	for name, value := range items {
+		if value == "" {
+			continue
+		}
+
+		names = append(names, name)
+
+		for _, task := range tasks {
+			if task.Env == nil {
+				task.Env = make(map[string]string)
+			}
+
+			task.Env[name] = value
+		}
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+func isNotFound(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "404")
+}
+
+func setVariableTemplate(task *api.Task, path string) {
+	data := fmt.Sprintf("{{ with nomadVar %q }}{{ range .Tuples }}{{ .K }}={{ .V | toJSON }}\n{{ end }}{{ end }}", path)
+
+	for _, template := range task.Templates {
+		if template.DestPath != nil && *template.DestPath == variableTemplateDestPath {
+			template.EmbeddedTmpl = &data
+			template.Envvars = pointerOf(true)
+			return
+		}
+	}
+
+	task.Templates = append(task.Templates, &api.Template{
+		DestPath:     pointerOf(variableTemplateDestPath),
+		EmbeddedTmpl: &data,
+		Envvars:      pointerOf(true),
+	})
+}
@@ -0,0 +1,104 @@
+package nomad
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/nomad/api"
+)
+
+// newVariablesServer serves the agent version and, when variables is true, an empty variables store
+func newVariablesServer(t *testing.T, build string, variables bool) *api.Client {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/v1/agent/self" && build != "":
+			json.NewEncoder(w).Encode(api.AgentSelf{Member: api.AgentMember{Tags: map[string]string{"build": build}}})
+		case r.URL.Path == "/v1/vars" && variables:
+			json.NewEncoder(w).Encode([]*api.VariableMetadata{})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	nomadCli, err := api.NewClient(&api.Config{Address: server.URL})
+	if err != nil {
+		t.Fatalf("api.NewClient() error = %v", err)
+	}
+
+	return nomadCli
+}
+
+func TestPrepareJobVariable(t *testing.T) {
+	tests := []struct {
+		name         string
+		build        string
+		variables    bool
+		wantVariable bool
+		wantEnv      map[string]string
+	}{
+		{
+			name:         "variables supported",
+			build:        "1.6.1",
+			variables:    true,
+			wantVariable: true,
+			// the empty item has no stored value to replace the env
+			wantEnv: map[string]string{"EDGE": "1", "EDGE_KEY": "old-key"},
+		},
+		{
+			name:    "nomad older than 1.4",
+			build:   "1.3.5",
+			wantEnv: map[string]string{"EDGE": "1", "AGENT_SECRET": "new-secret", "EDGE_KEY": "old-key"},
+		},
+		{
+			name:    "unknown version without variables endpoint",
+			wantEnv: map[string]string{"EDGE": "1", "AGENT_SECRET": "new-secret", "EDGE_KEY": "old-key"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nomadCli := newVariablesServer(t, tt.build, tt.variables)
+
+			job := &api.Job{ID: pointerOf("agent"), Namespace: pointerOf("default")}
+			task := &api.Task{Name: "agent", Env: map[string]string{"EDGE": "1", "AGENT_SECRET": "old-secret", "EDGE_KEY": "old-key"}}
+
+			variable, err := prepareJobVariable(context.Background(), nomadCli, job, []*api.Task{task}, map[string]string{
+				"AGENT_SECRET": "new-secret",
+				"EDGE_KEY":     "",
+			})
+			if err != nil {
+				t.Fatalf("prepareJobVariable() error = %v", err)
+			}
+
+			if (variable != nil) != tt.wantVariable {
+				t.Fatalf("prepareJobVariable() variable = %v, want variable %v", variable, tt.wantVariable)
+			}
+
+			if !reflect.DeepEqual(task.Env, tt.wantEnv) {
+				t.Errorf("prepareJobVariable() task env = %v, want %v", task.Env, tt.wantEnv)
+			}
+
+			if !tt.wantVariable {
+				if len(task.Templates) > 0 {
+					t.Errorf("prepareJobVariable() added a template without variables")
+				}
+
+				return
+			}
+
+			if variable.variable.Items["AGENT_SECRET"] != "new-secret" {
+				t.Errorf("prepareJobVariable() items = %v, want AGENT_SECRET", variable.variable.Items)
+			}
+
+			if len(task.Templates) != 1 || !strings.Contains(*task.Templates[0].EmbeddedTmpl, jobVariablePath(job)) {
+				t.Errorf("prepareJobVariable() templates = %v, want the job variable template", task.Templates)
+			}
+		})
+	}
+}
@@ -2,7 +2,9 @@ package portainer
 
 import (
 	"context"
+	"time"
 
+	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/swarm"
 	"github.com/docker/docker/client"
@@ -10,6 +12,7 @@ import (
 	"github.com/portainer/portainer-updater/dockerstandalone"
 	"github.com/portainer/portainer-updater/dockerswarm"
 	"github.com/portainer/portainer-updater/kubernetes"
+	"github.com/portainer/portainer-updater/nomad"
 	"github.com/rs/zerolog/log"
 )
 
@@ -19,12 +22,31 @@ const (
 	EnvTypeDockerStandalone EnvType = "standalone"
 	EnvTypeKubernetes       EnvType = "kubernetes"
 	EnvTypeSwarm            EnvType = "swarm"
+	EnvTypeNomad            EnvType = "nomad"
 )
 
 type Command struct {
-	EnvType EnvType `help:"The environment type" default:"standalone" enum:"standalone,swarm,kubernetes"`
-	License string  `help:"License key to use for Portainer EE"`
-	Image   string  `help:"Image of portainer to upgrade to. e.g. portainer/portainer-ee:latest" name:"image" default:"portainer/portainer-ee:latest"`
+	EnvType EnvType       `help:"The environment type" default:"standalone" enum:"standalone,swarm,kubernetes,nomad"`
+	License string        `help:"License key to use for Portainer EE"`
+	Image   string        `help:"Image of portainer to upgrade to. e.g. portainer/portainer-ee:latest" name:"image" default:"portainer/portainer-ee:latest"`
+	Timeout time.Duration `help:"Maximum time to wait for the update to complete" default:"5m"`
+
+	ComposeRewrite bool   `help:"Update the image in the compose file when the Portainer container is managed by docker compose"`
+	ComposeFile    string `help:"Path of the compose file to update, mounted in the updater container. Defaults to the files listed in the container compose labels"`
+	Container      string `help:"ID or name of the Portainer container to update, discovered when empty"`
+	Podman         bool   `help:"Update the Portainer container through the Docker compatible API of Podman, detected automatically when not set"`
+
+	Service       string                  `help:"Name or ID of the Docker Swarm service running Portainer, discovered when empty"`
+	UpdateOrder   string                  `help:"Order of operations when Docker Swarm replaces the Portainer task (stop-first or start-first), the service setting is kept when empty"`
+	UpdateMonitor time.Duration           `help:"Duration Docker Swarm monitors the new task for failure, the service setting is kept when 0" default:"0"`
+	StackPolicy   dockerswarm.StackPolicy `help:"How to handle a Docker Swarm service deployed with docker stack deploy" default:"warn" enum:"warn,refuse"`
+	StackSnippet  bool                    `help:"Print the stack file changes matching the update when the service is part of a stack"`
+
+	RWOStrategy kubernetes.RWOStrategy `help:"How to update a Kubernetes deployment using a ReadWriteOnce volume with a rolling update (recreate switches to the Recreate strategy during the update)" default:"recreate" enum:"recreate,warn" name:"rwo-strategy"`
+
+	NomadNamespace  string `help:"Namespace of the Nomad job running Portainer, all namespaces are searched when empty"`
+	NomadJob        string `help:"ID of the Nomad job running Portainer, discovered by the io.portainer.server meta or the image when empty"`
+	NomadLicenseEnv bool   `help:"Write the license key in the task env of the job spec instead of the Nomad Variable of the job, the task env is used when the cluster doesn't support variables"`
 }
 
 func (r *Command) Run() error {
@@ -39,6 +61,8 @@ func (r *Command) Run() error {
 		return r.runSwarm(ctx)
 	case EnvTypeKubernetes:
 		return r.runKubernetes(ctx)
+	case EnvTypeNomad:
+		return r.runNomad(ctx)
 	}
 
 	return errors.Errorf("unknown environment type: %s", r.EnvType)
@@ -59,12 +83,28 @@ func (r *Command) runKubernetes(ctx context.Context) error {
 		return errors.WithMessage(err, "failed finding deployment")
 	}
 
-	log.Debug().
-		Str("deployment", deployment.Name).
-		Msg("Found deployment")
+	if deployment != nil {
+		log.Debug().
+			Str("deployment", deployment.Name).
+			Msg("Found deployment")
+
+		return kubernetes.Update(ctx, cli, r.Image, deployment, r.License, r.Timeout, r.RWOStrategy)
+	}
+
+	statefulSet, err := kubernetes.FindPortainerStatefulSet(ctx, cli)
+	if err != nil {
+		return errors.WithMessage(err, "failed finding statefulset")
+	}
 
-	return kubernetes.Update(ctx, cli, r.Image, deployment, r.License)
+	if statefulSet == nil {
+		return errors.New("no deployment or statefulset found")
+	}
 
+	log.Debug().
+		Str("statefulSet", statefulSet.Name).
+		Msg("Found statefulset")
+
+	return kubernetes.UpdateStatefulSet(ctx, cli, r.Image, statefulSet, r.License, r.Timeout)
 }
 
 func (r *Command) runStandalone(ctx context.Context) error {
@@ -77,16 +117,29 @@ func (r *Command) runStandalone(ctx context.Context) error {
 		Str("image", r.Image).
 		Msg("Updating Portainer on standalone environment")
 
-	oldContainer, err := dockerstandalone.FindPortainerContainer(ctx, dockerCli)
+	var oldContainer *types.Container
+	if r.Container != "" {
+		oldContainer, err = dockerstandalone.FindPortainerContainerByName(ctx, dockerCli, r.Container)
+	} else {
+		oldContainer, err = dockerstandalone.FindPortainerContainer(ctx, dockerCli)
+	}
 	if err != nil {
 		return errors.WithMessage(err, "failed finding container")
 	}
 
-	return dockerstandalone.Update(ctx, dockerCli, oldContainer.ID, r.Image, func(config *container.Config) {
+	options := dockerstandalone.UpdateOptions{
+		RewriteComposeFile: r.ComposeRewrite,
+		ComposeFile:        r.ComposeFile,
+		Podman:             r.Podman,
+	}
+
+	_, err = dockerstandalone.Update(ctx, dockerCli, oldContainer.ID, r.Image, options, func(config *container.Config) {
 		if r.License != "" {
 			config.Env = append(config.Env, "PORTAINER_LICENSE_KEY="+r.License)
 		}
 	})
+
+	return err
 }
 
 func (r *Command) runSwarm(ctx context.Context) error {
@@ -99,14 +152,70 @@ func (r *Command) runSwarm(ctx context.Context) error {
 		Str("image", r.Image).
 		Msg("Updating Portainer on swarm environment")
 
-	service, err := dockerswarm.FindPortainerService(ctx, dockerCli)
+	service, err := dockerswarm.FindPortainerService(ctx, dockerCli, r.Service)
 	if err != nil {
 		return errors.WithMessage(err, "failed finding container id")
 	}
 
-	return dockerswarm.Update(ctx, dockerCli, r.Image, service, func(config *swarm.ContainerSpec) {
+	if r.UpdateOrder != "" && r.UpdateOrder != swarm.UpdateOrderStopFirst && r.UpdateOrder != swarm.UpdateOrderStartFirst {
+		return errors.Errorf("invalid update order %q, expected stop-first or start-first", r.UpdateOrder)
+	}
+
+	options := dockerswarm.UpdateOptions{
+		Order:   r.UpdateOrder,
+		Monitor: r.UpdateMonitor,
+		Timeout: r.Timeout,
+
+		StackPolicy:       r.StackPolicy,
+		PrintStackSnippet: r.StackSnippet,
+	}
+
+	if r.License != "" {
+		// the license is referenced from the environment so it isn't committed with the stack file
+		options.StackEnv = append(options.StackEnv, "PORTAINER_LICENSE_KEY=${PORTAINER_LICENSE_KEY}")
+	}
+
+	return dockerswarm.Update(ctx, dockerCli, r.Image, service, options, func(config *swarm.ContainerSpec) {
 		if r.License != "" {
 			config.Env = append(config.Env, "PORTAINER_LICENSE_KEY="+r.License)
 		}
 	})
 }
+
+func (r *Command) runNomad(ctx context.Context) error {
+	nomadCli, err := nomad.GetClient()
+	if err != nil {
+		return err
+	}
+
+	log.Info().
+		Str("image", r.Image).
+		Msg("Updating Portainer on nomad environment")
+
+	job, tasks, err := nomad.FindPortainerJob(ctx, nomadCli, nomad.FindOptions{
+		Namespace: r.NomadNamespace,
+		JobID:     r.NomadJob,
+	})
+	if err != nil {
+		return errors.WithMessage(err, "failed finding job")
+	}
+
+	options := nomad.UpdateOptions{Timeout: r.Timeout}
+
+	if r.License != "" {
+		if r.NomadLicenseEnv {
+			for _, task := range tasks {
+				if task.Env == nil {
+					task.Env = make(map[string]string)
+				}
+
+				task.Env["PORTAINER_LICENSE_KEY"] = r.License
+			}
+		} else {
+			// the license is kept out of the job spec, readable by anyone allowed to read jobs
+			options.SecretEnv = map[string]string{"PORTAINER_LICENSE_KEY": r.License}
+		}
+	}
+
+	return nomad.Update(ctx, nomadCli, job, tasks, r.Image, "", options)
+}